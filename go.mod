@@ -9,9 +9,11 @@ require (
 	github.com/adrg/xdg v0.3.3 // indirect
 	github.com/go-gl/gl v0.0.0-20210813123233-e4099ee2221f
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20210727001814-0db043d8d5be
+	github.com/go-text/typesetting v0.2.1
 	github.com/neovim/go-client v1.1.7
 	github.com/olekukonko/tablewriter v0.0.5
+	github.com/rivo/uniseg v0.2.0
 	github.com/sqweek/dialog v0.0.0-20210702151303-c326b49d3f01
-	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
-	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/image v0.3.0
+	golang.org/x/text v0.9.0
 )
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+const (
+	// When the atlas is full and can't grow, this fraction of the glyphs are
+	// evicted at once for making space to the next glyphs.
+	ATLAS_EVICT_DIVISOR = 4
+	// Shaped cells which have more glyphs than this are keyed with the
+	// overflow string of the glyph key.
+	GLYPH_KEY_MAX_GLYPHS = 4
+)
+
+type GlyphKind uint8
+
+const (
+	GLYPH_CHAR GlyphKind = iota
+	GLYPH_SHAPED
+	GLYPH_UNSUPPORTED
+	GLYPH_UNDERCURL
+)
+
+// Key of a glyph in the atlas. Keys are comparable structs, so looking up a
+// glyph for every drawn cell doesn't allocate.
+type GlyphKey struct {
+	kind GlyphKind
+	// Face and size of the glyph, nil for the unsupported glyph and undercurl.
+	// Size of the unsupported glyph is the scale of it's cells.
+	face *FontFace
+	size float64
+	// Text of the cell. Grapheme clusters are interned and their ids are
+	// unique for the text.
+	char rune
+	// Glyphs of the shaped cells.
+	glyphs     [GLYPH_KEY_MAX_GLYPHS]CellGlyph
+	glyphCount int
+	overflow   string
+	// Style and decorations.
+	wide, italic, bold, underline, strikethrough bool
+}
+
+// Returns the key of a shaped cell with the glyphs.
+func shapedGlyphKey(face *FontFace, glyphs []CellGlyph, underline, strikethrough bool) GlyphKey {
+	key := GlyphKey{
+		kind:          GLYPH_SHAPED,
+		face:          face,
+		size:          face.size,
+		glyphCount:    len(glyphs),
+		underline:     underline,
+		strikethrough: strikethrough,
+	}
+	if len(glyphs) > GLYPH_KEY_MAX_GLYPHS {
+		key.overflow = fmt.Sprint(glyphs[GLYPH_KEY_MAX_GLYPHS:])
+	}
+	copy(key.glyphs[:], glyphs)
+	return key
+}
+
+// A glyph in the atlas.
+type AtlasGlyph struct {
+	pos IntRect
+	// Frame of the glyph's last usage, least recently used glyphs are evicted
+	// first.
+	lastUse uint64
+	// Pinned glyphs are never evicted.
+	pinned bool
+}
+
+// A free part of a shelf.
+type AtlasSpan struct {
+	x, width int
+}
+
+// A row of the atlas which has the height of a cell. Glyphs are placed to the
+// shelves with their own heights, so the shelves are never wasted vertically.
+// Only the zoomed grids have the glyphs with other heights. Glyphs have
+// different widths and they are placed to the free spans of the shelf.
+type AtlasShelf struct {
+	y, height int
+	// Sorted by x and never adjacent.
+	free []AtlasSpan
+}
+
+// FontAtlas is the texture of the rendered glyphs. Glyphs are packed to the
+// shelves, and the texture grows when there is no space for a new glyph. When
+// the texture reaches it's maximum size, least recently used glyphs are
+// evicted instead.
+type FontAtlas struct {
+	texture Texture
+	maxSize int
+	shelves []AtlasShelf
+	glyphs  map[GlyphKey]*AtlasGlyph
+	// Top left positions of the cell sized parts of the color glyphs. Wide
+	// glyphs are drawn on two cells and both parts are stored.
+	colored map[IntVec2]bool
+	// Frame counter, incremented for every draw.
+	frame uint64
+}
+
+func CreateFontAtlas() FontAtlas {
+	return FontAtlas{
+		texture: CreateTexture(FONT_ATLAS_DEFAULT_SIZE, FONT_ATLAS_DEFAULT_SIZE),
+		maxSize: min(FONT_ATLAS_MAX_SIZE, RGL.max_texture_size),
+		glyphs:  make(map[GlyphKey]*AtlasGlyph),
+		colored: make(map[IntVec2]bool),
+	}
+}
+
+// Removes all glyphs from the atlas. Size of the texture is not changed.
+func (atlas *FontAtlas) clear() {
+	atlas.texture.clear()
+	atlas.shelves = nil
+	atlas.glyphs = make(map[GlyphKey]*AtlasGlyph)
+	atlas.colored = make(map[IntVec2]bool)
+}
+
+// Returns the position of the glyph with the key, and marks it as used.
+func (atlas *FontAtlas) get(key GlyphKey) (IntRect, bool) {
+	glyph, ok := atlas.glyphs[key]
+	count_cache_access("FontAtlas", ok)
+	if !ok {
+		return IntRect{}, false
+	}
+	glyph.lastUse = atlas.frame
+	return glyph.pos, true
+}
+
+// Adds the image to the atlas with the key and returns it's position. Height
+// of the image must be the height of the cells it's drawn on. Color glyphs
+// are not tinted by the shader.
+func (atlas *FontAtlas) add(key GlyphKey, img *image.RGBA, colored, pinned bool) IntRect {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	pos, ok := atlas.allocate(width, height)
+	for !ok && (atlas.grow() || atlas.evict(width)) {
+		pos, ok = atlas.allocate(width, height)
+	}
+	if !ok {
+		// Only possible if the glyphs in this frame are more than the atlas
+		// can hold.
+		logMessage(LEVEL_ERROR, TYPE_RENDERER, "Font atlas is full.")
+		atlas.clear()
+		singleton.renderer.atlasChanged()
+		pos, ok = atlas.allocate(width, height)
+		assert(ok, "atlas: glyph doesn't fit to the empty atlas, width:", width)
+	}
+	atlas.texture.updatePart(img, pos)
+	atlas.glyphs[key] = &AtlasGlyph{pos: pos, lastUse: atlas.frame, pinned: pinned}
+	if colored {
+		atlas.colored[IntVec2{X: pos.X, Y: pos.Y}] = true
+		if key.wide {
+			atlas.colored[IntVec2{X: pos.X + pos.W/2, Y: pos.Y}] = true
+		}
+	}
+	return pos
+}
+
+// Returns 1 if the position is a color glyph, otherwise 0. The value is
+// used by the shader.
+func (atlas *FontAtlas) coloredValue(pos IntRect) float32 {
+	if pos.W == 0 {
+		// Empty cells have no glyph.
+		return 0
+	}
+	if atlas.colored[IntVec2{X: pos.X, Y: pos.Y}] {
+		return 1
+	}
+	return 0
+}
+
+// Finds a free space for a glyph with the size. The first span that is wide
+// enough in the shelves with the same height is used, and a new shelf is
+// created if there is no such span. New shelves are placed to the first gap
+// that is tall enough, which the removed shelves left.
+func (atlas *FontAtlas) allocate(width, height int) (IntRect, bool) {
+	for i := range atlas.shelves {
+		shelf := &atlas.shelves[i]
+		if shelf.height != height {
+			continue
+		}
+		for j, span := range shelf.free {
+			if span.width >= width {
+				shelf.free[j].x += width
+				shelf.free[j].width -= width
+				if shelf.free[j].width == 0 {
+					shelf.free = append(shelf.free[:j], shelf.free[j+1:]...)
+				}
+				return IntRect{X: span.x, Y: shelf.y, W: width, H: height}, true
+			}
+		}
+	}
+	// Shelves are sorted by y.
+	y := 0
+	index := len(atlas.shelves)
+	for i, shelf := range atlas.shelves {
+		if shelf.y-y >= height {
+			index = i
+			break
+		}
+		y = shelf.y + shelf.height
+	}
+	if y+height > atlas.texture.height || width > atlas.texture.width {
+		return IntRect{}, false
+	}
+	atlas.shelves = append(atlas.shelves, AtlasShelf{})
+	copy(atlas.shelves[index+1:], atlas.shelves[index:])
+	atlas.shelves[index] = AtlasShelf{
+		y:      y,
+		height: height,
+		free:   []AtlasSpan{{x: width, width: atlas.texture.width - width}},
+	}
+	return IntRect{X: 0, Y: y, W: width, H: height}, true
+}
+
+// Doubles the size of the texture by keeping the glyphs, returns false if the
+// texture is already at the maximum size.
+func (atlas *FontAtlas) grow() bool {
+	if atlas.texture.width >= atlas.maxSize {
+		return false
+	}
+	oldWidth := atlas.texture.width
+	size := min(oldWidth*2, atlas.maxSize)
+	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "Growing font atlas to", size)
+	atlas.texture.resize(size, size)
+	// Shelves are extended to the new width.
+	for i := range atlas.shelves {
+		shelf := &atlas.shelves[i]
+		last := len(shelf.free) - 1
+		if last >= 0 && shelf.free[last].x+shelf.free[last].width == oldWidth {
+			shelf.free[last].width += size - oldWidth
+		} else {
+			shelf.free = append(shelf.free, AtlasSpan{x: oldWidth, width: size - oldWidth})
+		}
+	}
+	// Texture coordinates of the glyphs are changed.
+	singleton.renderer.atlasChanged()
+	return true
+}
+
+// Evicts the least recently used glyphs which are not used in this frame.
+// Returns false if nothing is evicted.
+func (atlas *FontAtlas) evict(width int) bool {
+	candidates := []GlyphKey{}
+	for key, glyph := range atlas.glyphs {
+		if !glyph.pinned && glyph.lastUse < atlas.frame {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return atlas.glyphs[candidates[i]].lastUse < atlas.glyphs[candidates[j]].lastUse
+	})
+	count := max(len(atlas.glyphs)/ATLAS_EVICT_DIVISOR, 1)
+	evicted, freed := 0, 0
+	for _, key := range candidates {
+		if evicted >= count && freed >= width {
+			break
+		}
+		glyph := atlas.glyphs[key]
+		atlas.free(glyph.pos)
+		delete(atlas.glyphs, key)
+		evicted++
+		freed += glyph.pos.W
+	}
+	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "Evicted", evicted, "glyphs from the font atlas.")
+	// Evicted glyphs may be visible.
+	singleton.renderer.atlasChanged()
+	return true
+}
+
+// Gives the space of the glyph back to it's shelf. Empty shelves are removed,
+// so their space can be used by the glyphs with other heights.
+func (atlas *FontAtlas) free(pos IntRect) {
+	// Wide glyphs have two parts.
+	delete(atlas.colored, IntVec2{X: pos.X, Y: pos.Y})
+	delete(atlas.colored, IntVec2{X: pos.X + pos.W/2, Y: pos.Y})
+	for i := range atlas.shelves {
+		shelf := &atlas.shelves[i]
+		if shelf.y != pos.Y {
+			continue
+		}
+		// Insert the span by keeping the order and merge with neighbours.
+		index := sort.Search(len(shelf.free), func(j int) bool {
+			return shelf.free[j].x > pos.X
+		})
+		shelf.free = append(shelf.free, AtlasSpan{})
+		copy(shelf.free[index+1:], shelf.free[index:])
+		shelf.free[index] = AtlasSpan{x: pos.X, width: pos.W}
+		if index+1 < len(shelf.free) && pos.X+pos.W == shelf.free[index+1].x {
+			shelf.free[index].width += shelf.free[index+1].width
+			shelf.free = append(shelf.free[:index+1], shelf.free[index+2:]...)
+		}
+		if index > 0 && shelf.free[index-1].x+shelf.free[index-1].width == pos.X {
+			shelf.free[index-1].width += shelf.free[index].width
+			shelf.free = append(shelf.free[:index], shelf.free[index+1:]...)
+		}
+		if len(shelf.free) == 1 && shelf.free[0].width == atlas.texture.width {
+			atlas.shelves = append(atlas.shelves[:i], atlas.shelves[i+1:]...)
+		}
+		return
+	}
+}
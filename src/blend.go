@@ -0,0 +1,106 @@
+package main
+
+// Floats with winblend are drawn to the screen cells, and their cells are
+// blended with the cells under them like the compositor of neovim does. Cells
+// of the popup menu and the zoomed floats are in their own vertex data above
+// the screen cells, their backgrounds are made translucent instead. The text
+// under them is visible through the background but their text is not faded.
+
+// Returns the color between the colors, blend is the percentage of the first
+// color. Same with rgb_blend of neovim.
+func blendColor(blend int, c1, c2 U8Color) U8Color {
+	blend = clamp(blend, 0, 100)
+	mix := func(a, b uint8) uint8 {
+		return uint8((blend*int(a) + (100-blend)*int(b)) / 100)
+	}
+	return U8Color{
+		R: mix(c1.R, c2.R),
+		G: mix(c1.G, c2.G),
+		B: mix(c1.B, c2.B),
+		A: mix(c1.A, c2.A),
+	}
+}
+
+// Returns true if the cell of the grid is blended with the cells under it.
+func (grid *Grid) isBlended(cell Cell) bool {
+	if grid.typ != GridTypeFloat || cell.attribId <= 0 {
+		return false
+	}
+	return singleton.gridManager.attributes[cell.attribId].blend > 0
+}
+
+// Returns the cell and it's attribute which is under the grid at the screen
+// cell, and true if the cell is the left half of a wide character. Only the
+// grids drawn to the screen cells are looked.
+func (gridManager *GridManager) cellBelow(grid *Grid, x, y int) (Cell, HighlightAttribute, bool) {
+	below := false
+	for i := len(gridManager.sortedGrids) - 1; i >= 0; i-- {
+		other := gridManager.sortedGrids[i]
+		if other == grid {
+			below = true
+			continue
+		}
+		if !below || other.hidden || !other.inScreenCells() {
+			continue
+		}
+		row, col := x-other.sRow, y-other.sCol
+		if row < 0 || col < 0 || row >= other.rows || col >= other.cols {
+			continue
+		}
+		cell := other.getCell(row, col)
+		attrib := HighlightAttribute{}
+		if cell.attribId > 0 {
+			attrib = gridManager.attributes[cell.attribId]
+		}
+		return cell, attrib, other.isWide(row, col)
+	}
+	return Cell{}, HighlightAttribute{}, false
+}
+
+// Marks the cell of the top grid at the screen cell for drawing if it's
+// blended. Blended cells must be drawn again when the cells under them change.
+func (gridManager *GridManager) redrawBlendedCell(x, y int) {
+	cols := singleton.renderer.cols
+	index := x*cols + y
+	if x < 0 || y < 0 || y >= cols || index >= len(gridManager.cellOwners) {
+		return
+	}
+	owner := gridManager.cellOwners[index]
+	if owner == nil {
+		return
+	}
+	row, col := x-owner.sRow, y-owner.sCol
+	if row < 0 || col < 0 || row >= owner.rows || col >= owner.cols {
+		return
+	}
+	if owner.isBlended(owner.cells[row][col]) {
+		owner.cells[row][col].needsDraw = true
+	}
+}
+
+// Draws the cell of the grid to the screen cell. Blended cells show the cells
+// under them, the character under a blank cell is visible through it. The
+// text of other cells fades to the background under them with the half of
+// the blend, like the hl_blend_attrs of neovim.
+func (renderer *Renderer) drawGridCell(grid *Grid, x, y int, cell Cell, wide bool) {
+	if !grid.isBlended(cell) {
+		renderer.DrawCell(grid.sRow+x, grid.sCol+y, cell, wide)
+		return
+	}
+	gridManager := &singleton.gridManager
+	attrib := gridManager.attributes[cell.attribId]
+	under, underAttrib, underWide := gridManager.cellBelow(grid, grid.sRow+x, grid.sCol+y)
+	fg, bg, sp := gridManager.attribColors(attrib)
+	underFg, underBg, underSp := gridManager.attribColors(underAttrib)
+	blankUnder := under.char == 0 || under.char == WIDE_CHAR_CONTINUATION || underWide
+	if (cell.char == 0 || cell.char == ' ') && !blankUnder && under.char != ' ' {
+		renderer.DrawCellCustom(grid.sRow+x, grid.sCol+y, under.char, false,
+			blendColor(attrib.blend, underFg, bg), blendColor(attrib.blend, underBg, bg), underSp,
+			underAttrib.italic, underAttrib.bold, underAttrib.underline, underAttrib.undercurl,
+			underAttrib.strikethrough)
+		return
+	}
+	renderer.DrawCellCustom(grid.sRow+x, grid.sCol+y, cell.char, wide,
+		blendColor(attrib.blend/2, underBg, fg), blendColor(attrib.blend, underBg, bg), sp,
+		attrib.italic, attrib.bold, attrib.underline, attrib.undercurl, attrib.strikethrough)
+}
@@ -0,0 +1,352 @@
+package main
+
+// Box drawing characters used for the border of the command line and messages.
+var borderChars = [6]rune{'╭', '─', '╮', '│', '╰', '╯'}
+
+// TextChunk is a text with highlight attribute, command line and message
+// contents are sent as chunks.
+type TextChunk struct {
+	attribId int
+	text     string
+}
+
+type CmdlineLevel struct {
+	content []TextChunk
+	// Byte position of the cursor in content.
+	pos    int
+	firstc string
+	prompt string
+	indent int
+	// Special character is shown at the cursor position, and if shift is
+	// true the text after the cursor is shifted right.
+	specialChar  string
+	specialShift bool
+}
+
+type textCell struct {
+	char     rune
+	attribId int
+}
+
+// Cmdline is the neovim's command line, only used when ext_cmdline is
+// enabled. Neoray draws it as a floating palette on top of the grids.
+type Cmdline struct {
+	// Index of the level is level - 1
+	levels []CmdlineLevel
+	block  [][]TextChunk
+	hidden bool
+	// Rows of the palette without the border.
+	rows [][]textCell
+	// Cursor position in rows.
+	cursorRow, cursorCol int
+	// Position and size of the palette including the border in cells.
+	sRow, sCol    int
+	width, height int
+	// Last cell of the vertex data is cursor. And the vertex data only
+	// recreated when palette needs more cells than capacity.
+	vertexData VertexDataStorage
+	capacity   int
+}
+
+func CreateCmdline() Cmdline {
+	return Cmdline{
+		hidden: true,
+	}
+}
+
+func (cmdline *Cmdline) createVertexData() {
+	cmdline.vertexData = singleton.renderer.reserveVertexData(cmdline.capacity + 1)
+	if !cmdline.hidden {
+		cmdline.Draw()
+	}
+}
+
+func (cmdline *Cmdline) Show(level int, content []TextChunk, pos int, firstc, prompt string, indent int) {
+	if level <= 0 {
+		return
+	}
+	// Levels higher than this one are closed.
+	if len(cmdline.levels) >= level {
+		cmdline.levels = cmdline.levels[:level]
+	} else {
+		for len(cmdline.levels) < level {
+			cmdline.levels = append(cmdline.levels, CmdlineLevel{})
+		}
+	}
+	cmdline.levels[level-1] = CmdlineLevel{
+		content: content,
+		pos:     pos,
+		firstc:  firstc,
+		prompt:  prompt,
+		indent:  indent,
+	}
+	cmdline.hidden = false
+	cmdline.update()
+}
+
+func (cmdline *Cmdline) SetPos(pos, level int) {
+	if level > 0 && level <= len(cmdline.levels) {
+		cmdline.levels[level-1].pos = pos
+		cmdline.levels[level-1].specialChar = ""
+		cmdline.update()
+	}
+}
+
+func (cmdline *Cmdline) SetSpecialChar(char string, shift bool, level int) {
+	if level > 0 && level <= len(cmdline.levels) {
+		cmdline.levels[level-1].specialChar = char
+		cmdline.levels[level-1].specialShift = shift
+		cmdline.update()
+	}
+}
+
+// Hides the given level. If level is zero the top level will be hidden.
+func (cmdline *Cmdline) Hide(level int) {
+	if level <= 0 || level > len(cmdline.levels) {
+		level = len(cmdline.levels)
+	}
+	if level > 0 {
+		cmdline.levels = cmdline.levels[:level-1]
+	}
+	if len(cmdline.levels) == 0 && len(cmdline.block) == 0 {
+		cmdline.hide()
+		return
+	}
+	cmdline.update()
+}
+
+func (cmdline *Cmdline) BlockShow(lines [][]TextChunk) {
+	cmdline.block = lines
+	cmdline.hidden = false
+	cmdline.update()
+}
+
+func (cmdline *Cmdline) BlockAppend(line []TextChunk) {
+	cmdline.block = append(cmdline.block, line)
+	cmdline.update()
+}
+
+func (cmdline *Cmdline) BlockHide() {
+	cmdline.block = nil
+	if len(cmdline.levels) == 0 {
+		cmdline.hide()
+		return
+	}
+	cmdline.update()
+}
+
+func (cmdline *Cmdline) hide() {
+	for i := 0; i <= cmdline.capacity; i++ {
+		cmdline.vertexData.setCellPos(i, F32Rect{})
+	}
+	cmdline.hidden = true
+	// Cursor is hidden while command line is visible.
+	singleton.cursor.needsDraw = true
+	singleton.render()
+}
+
+// Recalculates rows and size of the palette and draws it.
+func (cmdline *Cmdline) update() {
+	cmdline.createRows()
+	cmdline.calcPosition()
+	if cmdline.width*cmdline.height > cmdline.capacity {
+		cmdline.capacity = cmdline.width * cmdline.height
+		// This also draws the command line.
+		singleton.renderer.createVertexData()
+		singleton.fullDraw()
+		return
+	}
+	cmdline.Draw()
+	// Popup menu may be anchored to the command line.
+	if !singleton.popupMenu.hidden && singleton.popupMenu.grid == -1 {
+		singleton.popupMenu.calcPosition()
+		singleton.popupMenu.Draw()
+	}
+}
+
+func appendChunks(row []textCell, chunks []TextChunk) []textCell {
+	for _, chunk := range chunks {
+		for _, c := range splitGraphemes(chunk.text) {
+			row = append(row, textCell{char: c, attribId: chunk.attribId})
+		}
+	}
+	return row
+}
+
+func appendText(row []textCell, text string) []textCell {
+	return appendChunks(row, []TextChunk{{text: text}})
+}
+
+// Creates the rows of the palette from block lines and levels. Every level
+// is a row, and the cursor is always at the top level.
+func (cmdline *Cmdline) createRows() {
+	cmdline.rows = cmdline.rows[:0]
+	for _, line := range cmdline.block {
+		cmdline.rows = append(cmdline.rows, appendChunks(nil, line))
+	}
+	for i, level := range cmdline.levels {
+		row := appendText(nil, level.firstc)
+		row = appendText(row, level.prompt)
+		for j := 0; j < level.indent; j++ {
+			row = append(row, textCell{})
+		}
+		prefix := len(row)
+		row = appendChunks(row, level.content)
+		// Convert byte position to the cell position.
+		col := prefix
+		text := ""
+		for _, chunk := range level.content {
+			text += chunk.text
+		}
+		if level.pos > 0 && level.pos <= len(text) {
+			col += len(splitGraphemes(text[:level.pos]))
+		}
+		if level.specialChar != "" {
+			special := internGrapheme(level.specialChar)
+			cell := textCell{char: special, attribId: singleton.gridManager.hlGroups["SpecialKey"]}
+			if level.specialShift || col >= len(row) {
+				row = append(row[:col], append([]textCell{cell}, row[col:]...)...)
+			} else {
+				row[col] = cell
+			}
+		}
+		if i == len(cmdline.levels)-1 {
+			cmdline.cursorRow = len(cmdline.rows)
+			cmdline.cursorCol = col
+		}
+		cmdline.rows = append(cmdline.rows, row)
+	}
+}
+
+// Calculates position and size of the palette. The palette is placed at the
+// top center of the window and it's width is at least half of the window.
+func (cmdline *Cmdline) calcPosition() {
+	rows := singleton.renderer.rows
+	cols := singleton.renderer.cols
+	longest := 0
+	for _, row := range cmdline.rows {
+		longest = max(longest, len(row))
+	}
+	// One cell for cursor at the end.
+	innerWidth := clamp(longest+1, cols/2, cols-4)
+	cmdline.width = max(innerWidth+2, 0)
+	// Long rows are wrapped.
+	innerHeight := 0
+	for _, row := range cmdline.rows {
+		innerHeight += max((len(row)+innerWidth)/max(innerWidth, 1), 1)
+	}
+	cmdline.height = min(innerHeight+2, rows)
+	cmdline.sRow = clamp(rows/4, 0, rows-cmdline.height)
+	cmdline.sCol = max((cols-cmdline.width)/2, 0)
+}
+
+// Returns global position of the popup menu anchor for given column.
+func (cmdline *Cmdline) popupAnchor(col int) (int, int) {
+	innerWidth := max(cmdline.width-2, 1)
+	row := cmdline.sRow + cmdline.height - 1
+	if len(cmdline.levels) > 0 {
+		level := cmdline.levels[len(cmdline.levels)-1]
+		col += len([]rune(level.firstc+level.prompt)) + level.indent
+	}
+	return row, cmdline.sCol + 1 + col%innerWidth
+}
+
+func (cmdline *Cmdline) Draw() {
+	if cmdline.hidden {
+		return
+	}
+	innerWidth := max(cmdline.width-2, 1)
+	innerHeight := max(cmdline.height-2, 0)
+	// Wrap rows to the palette width and find the cursor.
+	wrapped := [][]textCell{}
+	cursorRow, cursorCol := 0, 0
+	for i, row := range cmdline.rows {
+		begin := len(wrapped)
+		for j := 0; j == 0 || j < len(row); j += innerWidth {
+			wrapped = append(wrapped, row[j:min(j+innerWidth, len(row))])
+		}
+		if i == cmdline.cursorRow {
+			if cmdline.cursorCol >= len(row) && len(row) > 0 && len(row)%innerWidth == 0 {
+				wrapped = append(wrapped, nil)
+			}
+			cursorRow = begin + cmdline.cursorCol/innerWidth
+			cursorCol = cmdline.cursorCol % innerWidth
+		}
+	}
+	// Only the last rows are visible if the palette is taller than the window.
+	firstRow := max(len(wrapped)-innerHeight, 0)
+	cursorRow -= firstRow
+	normal := singleton.gridManager.groupAttrib("MsgArea")
+	border := singleton.gridManager.groupAttrib("FloatBorder")
+	index := 0
+	for x := 0; x < cmdline.height; x++ {
+		for y := 0; y < cmdline.width; y++ {
+			char := rune(0)
+			attrib := normal
+			switch {
+			case x == 0 || x == cmdline.height-1 || y == 0 || y == cmdline.width-1:
+				attrib = border
+				char = borderChar(x, y, cmdline.width, cmdline.height)
+			case firstRow+x-1 < len(wrapped) && y-1 < len(wrapped[firstRow+x-1]):
+				cell := wrapped[firstRow+x-1][y-1]
+				char = cell.char
+				if char == ' ' {
+					char = 0
+				}
+				if cell.attribId > 0 {
+					attrib = singleton.gridManager.attributes[cell.attribId]
+				}
+			}
+			cmdline.vertexData.setCellPos(index, cellPos(cmdline.sRow+x, cmdline.sCol+y))
+			cmdline.vertexData.setCellWithAttrib(index, char, isWideGrapheme(char), attrib)
+			index++
+		}
+	}
+	for ; index < cmdline.capacity; index++ {
+		cmdline.vertexData.setCellPos(index, F32Rect{})
+	}
+	// Draw cursor
+	if cursorRow >= 0 && cursorRow < innerHeight {
+		pos := cellPos(cmdline.sRow+1+cursorRow, cmdline.sCol+1+cursorCol)
+		info := singleton.mode.Current()
+		fg, bg := singleton.cursor.modeColors(info)
+		char := rune(0)
+		if cursorRow+firstRow < len(wrapped) && cursorCol < len(wrapped[cursorRow+firstRow]) {
+			char = wrapped[cursorRow+firstRow][cursorCol].char
+		}
+		wide := char != 0 && isWideGrapheme(char)
+		rect, drawChar := singleton.cursor.modeRectangle(IntVec2{X: int(pos.X), Y: int(pos.Y)}, wide, info)
+		cmdline.vertexData.setCellPos(cmdline.capacity, rect)
+		cmdline.vertexData.setCellWithAttrib(cmdline.capacity, 0, false, HighlightAttribute{})
+		if drawChar && char != 0 && char != ' ' && char != WIDE_CHAR_CONTINUATION {
+			// Cursor is one quad and spans two cells for wide characters.
+			atlasPos := singleton.renderer.getCharPos(char, wide, false, false, false, false)
+			cmdline.vertexData.setCellTex1(cmdline.capacity, atlasPos)
+		}
+		cmdline.vertexData.setCellFg(cmdline.capacity, fg)
+		cmdline.vertexData.setCellBg(cmdline.capacity, bg)
+	} else {
+		cmdline.vertexData.setCellPos(cmdline.capacity, F32Rect{})
+	}
+	// Grid cursor will be hidden.
+	singleton.cursor.needsDraw = true
+	singleton.render()
+}
+
+// Returns the border character at the given position.
+func borderChar(x, y, width, height int) rune {
+	switch {
+	case x == 0 && y == 0:
+		return borderChars[0]
+	case x == 0 && y == width-1:
+		return borderChars[2]
+	case x == height-1 && y == 0:
+		return borderChars[4]
+	case x == height-1 && y == width-1:
+		return borderChars[5]
+	case x == 0 || x == height-1:
+		return borderChars[1]
+	default:
+		return borderChars[3]
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+
+	tsfont "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Color tables of a font. Emoji fonts have their color glyphs as layers of
+// outlines in the COLR and CPAL tables, or as images in the CBDT and sbix
+// tables.
+type ColorTables struct {
+	// Layers of the glyphs in the COLR table, keyed by the base glyph.
+	layers map[sfnt.GlyphIndex][]ColorLayer
+	// First palette of the CPAL table, colors are premultiplied.
+	palette []color.RGBA
+	// True if the font has a CBDT or sbix table.
+	bitmaps bool
+}
+
+type ColorLayer struct {
+	id sfnt.GlyphIndex
+	// Index of the color in the palette, 0xFFFF means the text color.
+	color uint16
+}
+
+// Loads the color tables of the font, the face has no color tables if the
+// font doesn't have any of them.
+func (face *FontFace) loadColorTables() {
+	loader := face.loader()
+	if loader == nil {
+		return
+	}
+	colors := ColorTables{
+		bitmaps: loader.HasTable(ot.MustNewTag("CBDT")) || loader.HasTable(ot.MustNewTag("sbix")),
+	}
+	if loader.HasTable(ot.MustNewTag("COLR")) && loader.HasTable(ot.MustNewTag("CPAL")) {
+		colr, _ := loader.RawTable(ot.MustNewTag("COLR"))
+		cpal, _ := loader.RawTable(ot.MustNewTag("CPAL"))
+		layers, err := parseColr(colr)
+		if err == nil {
+			colors.palette, err = parseCpal(cpal)
+		}
+		if err != nil {
+			logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to parse color tables of", face.FamilyName(), "err:", err)
+		} else {
+			colors.layers = layers
+		}
+	}
+	if len(colors.layers) > 0 || colors.bitmaps {
+		face.colors = &colors
+	}
+}
+
+// Only the version 0 of the COLR table is supported, which has solid colored
+// layers. Gradients of the version 1 are not supported.
+// https://learn.microsoft.com/en-us/typography/opentype/spec/colr
+func parseColr(data []byte) (map[sfnt.GlyphIndex][]ColorLayer, error) {
+	if len(data) < 14 {
+		return nil, fmt.Errorf("COLR table is too short")
+	}
+	baseCount := int(binary.BigEndian.Uint16(data[2:]))
+	baseOffset := int(binary.BigEndian.Uint32(data[4:]))
+	layerOffset := int(binary.BigEndian.Uint32(data[8:]))
+	layerCount := int(binary.BigEndian.Uint16(data[12:]))
+	if baseOffset+baseCount*6 > len(data) || layerOffset+layerCount*4 > len(data) {
+		return nil, fmt.Errorf("COLR table is malformed")
+	}
+	layers := make(map[sfnt.GlyphIndex][]ColorLayer, baseCount)
+	for i := 0; i < baseCount; i++ {
+		record := data[baseOffset+i*6:]
+		id := sfnt.GlyphIndex(binary.BigEndian.Uint16(record))
+		first := int(binary.BigEndian.Uint16(record[2:]))
+		count := int(binary.BigEndian.Uint16(record[4:]))
+		if first+count > layerCount {
+			return nil, fmt.Errorf("COLR layers of glyph %d are out of range", id)
+		}
+		glyphLayers := make([]ColorLayer, count)
+		for j := range glyphLayers {
+			layer := data[layerOffset+(first+j)*4:]
+			glyphLayers[j] = ColorLayer{
+				id:    sfnt.GlyphIndex(binary.BigEndian.Uint16(layer)),
+				color: binary.BigEndian.Uint16(layer[2:]),
+			}
+		}
+		layers[id] = glyphLayers
+	}
+	return layers, nil
+}
+
+// Returns the first palette of the CPAL table.
+// https://learn.microsoft.com/en-us/typography/opentype/spec/cpal
+func parseCpal(data []byte) ([]color.RGBA, error) {
+	if len(data) < 14 {
+		return nil, fmt.Errorf("CPAL table is too short")
+	}
+	entryCount := int(binary.BigEndian.Uint16(data[2:]))
+	recordCount := int(binary.BigEndian.Uint16(data[6:]))
+	recordsOffset := int(binary.BigEndian.Uint32(data[8:]))
+	first := int(binary.BigEndian.Uint16(data[12:]))
+	if first+entryCount > recordCount || recordsOffset+recordCount*4 > len(data) {
+		return nil, fmt.Errorf("CPAL table is malformed")
+	}
+	palette := make([]color.RGBA, entryCount)
+	for i := range palette {
+		// Colors are stored as BGRA and not premultiplied.
+		record := data[recordsOffset+(first+i)*4:]
+		a := uint16(record[3])
+		palette[i] = color.RGBA{
+			R: uint8(uint16(record[2]) * a / 255),
+			G: uint8(uint16(record[1]) * a / 255),
+			B: uint8(uint16(record[0]) * a / 255),
+			A: uint8(a),
+		}
+	}
+	return palette, nil
+}
+
+// Renders the color glyph of the character to an image with given size, the
+// dot is the origin of the glyph in the image. Returns nil if the character
+// has no color glyph, and true if the image must not be tinted. If colored is
+// false, bitmap glyphs are drawn like the outline glyphs and must be tinted
+// with the text color, and the layered glyphs are not drawn because their
+// base glyphs can be drawn instead.
+func (face *FontFace) renderColorGlyph(char rune, width, height int, dot fixed.Point26_6, colored bool) (*image.RGBA, bool) {
+	if face.colors == nil {
+		return nil, false
+	}
+	id, err := face.fontHandle.GlyphIndex(&face.buffer, char)
+	if err != nil || id == 0 {
+		return nil, false
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	drawn, isColor := face.drawColorGlyph(img, id, img.Rect, dot, colored)
+	if !drawn {
+		return nil, false
+	}
+	return img, isColor
+}
+
+// Draws the color glyph with given index to the image, layered glyphs are
+// drawn at the dot and bitmap glyphs are scaled to fit the box. Returns false
+// if the glyph is not a color glyph or it is not drawn, and true as the second
+// value if the glyph must not be tinted. Colored is same with the
+// renderColorGlyph.
+func (face *FontFace) drawColorGlyph(img *image.RGBA, id sfnt.GlyphIndex, box image.Rectangle, dot fixed.Point26_6, colored bool) (bool, bool) {
+	if face.colors == nil {
+		return false, false
+	}
+	if layers, ok := face.colors.layers[id]; ok {
+		if !colored {
+			return false, false
+		}
+		// Text color is not known when the glyph is rendered to the atlas.
+		// Glyphs which have layers with the text color are drawn like the
+		// outline glyphs, and tinted with the text color.
+		tinted := face.usesTextColor(layers)
+		face.drawLayeredGlyph(img, layers, dot, tinted)
+		return true, !tinted
+	}
+	if face.colors.bitmaps {
+		bitmap := face.renderBitmapGlyph(id, box.Dx(), box.Dy())
+		if bitmap == nil {
+			return false, false
+		}
+		if !colored {
+			monochromeImage(bitmap)
+		}
+		draw.Draw(img, box, bitmap, image.Point{}, draw.Over)
+		return true, colored
+	}
+	return false, false
+}
+
+// Returns true if a layer is drawn with the text color, which is the color
+// index 0xFFFF or an index that is not in the palette.
+func (face *FontFace) usesTextColor(layers []ColorLayer) bool {
+	for _, layer := range layers {
+		if int(layer.color) >= len(face.colors.palette) {
+			return true
+		}
+	}
+	return false
+}
+
+// Draws every layer of the glyph with it's color over the previous layers.
+// If tinted is true, every layer is drawn with white.
+func (face *FontFace) drawLayeredGlyph(img *image.RGBA, layers []ColorLayer, dot fixed.Point26_6, tinted bool) {
+	for _, layer := range layers {
+		dr, mask, ok := face.glyphMask(layer.id, dot)
+		if !ok {
+			continue
+		}
+		c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+		if !tinted {
+			c = face.colors.palette[layer.color]
+		}
+		draw.DrawMask(img, dr, image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+	}
+}
+
+// Decodes the bitmap of the glyph and scales it to fit the image. Aspect ratio
+// of the bitmap is preserved and it is centered in the image.
+func (face *FontFace) renderBitmapGlyph(id sfnt.GlyphIndex, width, height int) *image.RGBA {
+	handle := face.shapingFace()
+	if handle == nil {
+		return nil
+	}
+	// Nearest bitmap size to the ppem is chosen.
+	ppem := uint16(face.ppem.Round())
+	handle.SetPpem(ppem, ppem)
+	bitmap, ok := handle.GlyphData(tsfont.GID(id)).(tsfont.GlyphBitmap)
+	if !ok || (bitmap.Format != tsfont.PNG && bitmap.Format != tsfont.JPG) {
+		return nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(bitmap.Data))
+	if err != nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to decode bitmap glyph", id, "err:", err)
+		return nil
+	}
+	bounds := src.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	scale := f32min(float32(width)/float32(bounds.Dx()), float32(height)/float32(bounds.Dy()))
+	w := int(float32(bounds.Dx()) * scale)
+	h := int(float32(bounds.Dy()) * scale)
+	target := image.Rect((width-w)/2, (height-h)/2, (width-w)/2+w, (height-h)/2+h)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(img, target, src, bounds, draw.Over, nil)
+	return img
+}
+
+// Converts the colored image to a white image with the same alpha, so it can
+// be tinted like other glyphs.
+func monochromeImage(img *image.RGBA) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := img.Pix[i+3]
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = a, a, a
+	}
+}
@@ -106,11 +106,7 @@ func (cMenu *ContextMenu) updateChars() {
 			var atlasPos IntRect
 			if char != 0 {
 				atlasPos = singleton.renderer.getCharPos(
-					char, false, false, false, false)
-				// For multiwidth character.
-				if atlasPos.W > singleton.cellWidth {
-					atlasPos.W /= 2
-				}
+					char, false, false, false, false, false)
 			}
 			cMenu.vertexData.setCellTex1(cell_id, atlasPos)
 		}
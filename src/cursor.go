@@ -1,5 +1,13 @@
 package main
 
+import "math"
+
+const (
+	// Cursor is one quad, and the outline is four quads around the cell when
+	// the window is not focused.
+	CURSOR_VERTEX_COUNT = 5
+)
+
 type Cursor struct {
 	X, Y       int
 	grid       int
@@ -7,6 +15,7 @@ type Cursor struct {
 	needsDraw  bool
 	hidden     bool
 	vertexData VertexDataStorage
+	effect     CursorEffect
 	// blinking variables
 	bHidden  bool
 	time     float64
@@ -25,6 +34,20 @@ func (cursor *Cursor) update() {
 	if cursor.needsDraw {
 		cursor.Draw()
 	}
+	cursor.effect.update()
+}
+
+// Returns the seconds until the cursor needs an update, and false if it
+// doesn't need any. Animating cursor needs every frame.
+func (cursor *Cursor) nextUpdate() (float64, bool) {
+	if cursor.needsDraw || cursor.effect.isAnimating() {
+		return 0, true
+	}
+	info := singleton.mode.Current()
+	if cursor.hidden || !singleton.window.hasfocus || info.blinkwait <= 0 || info.blinkon <= 0 || info.blinkoff <= 0 {
+		return 0, false
+	}
+	return cursor.nextTime - cursor.time, true
 }
 
 func (cursor *Cursor) resetBlinking() {
@@ -59,16 +82,23 @@ func (cursor *Cursor) updateBlinking() {
 }
 
 func (cursor *Cursor) createVertexData() {
-	// Reserve vertex data for cursor and cursor is only one cell.
-	cursor.vertexData = singleton.renderer.reserveVertexData(1)
+	// Effects are drawn below the cursor.
+	cursor.effect.createVertexData()
+	cursor.vertexData = singleton.renderer.reserveVertexData(CURSOR_VERTEX_COUNT)
 }
 
 func (cursor *Cursor) setPosition(id, x, y int, immediately bool) {
 	if !immediately {
-		cursor.anim = CreateAnimation(
-			F32Vec2{X: float32(cursor.X), Y: float32(cursor.Y)},
+		from := F32Vec2{X: float32(cursor.X), Y: float32(cursor.Y)}
+		if id == cursor.grid && cursor.anim.isRunning() {
+			// Continue from the current position when the cursor moves again
+			// before the animation finishes.
+			from = cursor.anim.current
+		}
+		cursor.anim = CreateAnimation(from,
 			F32Vec2{X: float32(x), Y: float32(y)},
-			singleton.options.cursorAnimTime)
+			singleton.options.cursorAnimTime,
+			AnimationCurves[singleton.options.cursorAnimCurve])
 	}
 	cursor.X = x
 	cursor.Y = y
@@ -81,33 +111,90 @@ func (cursor *Cursor) isInArea(gridId, x, y, w, h int) bool {
 	return gridId == cursor.grid && cursor.X >= x && cursor.Y >= y && cursor.X < x+w && cursor.Y < y+h
 }
 
-func (cursor *Cursor) modeRectangle(cell_pos IntVec2, info ModeInfo) (F32Rect, bool) {
+// Returns the given percentage of the cell size in pixels. The cursor is at
+// least one pixel, and the percentage is full size when it is not valid.
+func cursorShapeSize(size, percentage int) float32 {
+	if percentage <= 0 || percentage > 100 {
+		percentage = 100
+	}
+	return f32max(float32(math.Round(float64(size*percentage)/100)), 1)
+}
+
+// If wide is true, the block and horizontal cursors spans two cells. Unknown
+// shapes are drawn as block, neovim sends no shape when the cursor style is
+// not enabled.
+func (cursor *Cursor) modeRectangle(cell_pos IntVec2, wide bool, info ModeInfo) (F32Rect, bool) {
+	cellWidth, cellHeight := cursor.cellSize()
+	width := float32(cellWidth)
+	if wide {
+		width *= 2
+	}
 	switch info.cursor_shape {
-	case "block":
-		return F32Rect{
-			X: float32(cell_pos.X),
-			Y: float32(cell_pos.Y),
-			W: float32(singleton.cellWidth),
-			H: float32(singleton.cellHeight),
-		}, true
 	case "horizontal":
-		height := float32(singleton.cellHeight) / (100 / float32(info.cell_percentage))
+		height := cursorShapeSize(cellHeight, info.cell_percentage)
 		return F32Rect{
 			X: float32(cell_pos.X),
-			Y: float32(cell_pos.Y) + (float32(singleton.cellHeight) - height),
-			W: float32(singleton.cellWidth),
+			Y: float32(cell_pos.Y) + (float32(cellHeight) - height),
+			W: width,
 			H: height,
 		}, false
 	case "vertical":
 		return F32Rect{
 			X: float32(cell_pos.X),
 			Y: float32(cell_pos.Y),
-			W: float32(singleton.cellWidth) / (100 / float32(info.cell_percentage)),
-			H: float32(singleton.cellHeight),
+			W: cursorShapeSize(cellWidth, info.cell_percentage),
+			H: float32(cellHeight),
 		}, false
 	default:
-		return F32Rect{}, false
+		return F32Rect{
+			X: float32(cell_pos.X),
+			Y: float32(cell_pos.Y),
+			W: width,
+			H: float32(cellHeight),
+		}, true
+	}
+}
+
+// Returns the cell size of the grid which the cursor is in.
+func (cursor *Cursor) cellSize() (int, int) {
+	if grid, ok := singleton.gridManager.grids[cursor.grid]; ok {
+		return grid.cellSize()
 	}
+	return singleton.cellWidth, singleton.cellHeight
+}
+
+// Draws the outline of the cell with the color, the cell under the cursor
+// stays visible.
+func (cursor *Cursor) drawOutline(cell_pos IntVec2, wide bool, color U8Color) {
+	cursor.vertexData.setCellPos(0, F32Rect{})
+	x, y := float32(cell_pos.X), float32(cell_pos.Y)
+	cellWidth, cellHeight := cursor.cellSize()
+	w, h := float32(cellWidth), float32(cellHeight)
+	if wide {
+		w *= 2
+	}
+	t := f32max(float32(math.Floor(singleton.window.dpi/96)), 1)
+	edges := [4]F32Rect{
+		{X: x, Y: y, W: w, H: t},
+		{X: x, Y: y + h - t, W: w, H: t},
+		{X: x, Y: y + t, W: t, H: h - 2*t},
+		{X: x + w - t, Y: y + t, W: t, H: h - 2*t},
+	}
+	for i, edge := range edges {
+		cursor.vertexData.setCellPos(1+i, edge)
+		cursor.vertexData.setCellTex1(1+i, IntRect{})
+		cursor.vertexData.setCellFg(1+i, U8Color{})
+		cursor.vertexData.setCellBg(1+i, color)
+		cursor.vertexData.setCellSp(1+i, U8Color{})
+	}
+}
+
+// Clears the cursor, the outline and the trail.
+func (cursor *Cursor) clear() {
+	for i := 0; i < CURSOR_VERTEX_COUNT; i++ {
+		cursor.vertexData.setCellPos(i, F32Rect{})
+	}
+	cursor.effect.clearTrail()
 }
 
 func (cursor *Cursor) modeColors(info ModeInfo) (U8Color, U8Color) {
@@ -127,21 +214,29 @@ func (cursor *Cursor) modeColors(info ModeInfo) (U8Color, U8Color) {
 }
 
 // This function returns the current rendering position of the cursor Not grid
-// position. sRow and sCol are grid positions for adding to cursor position.
-// Sets cursor.needsDraw to false when an animation finished.
-func (cursor *Cursor) animPosition(sRow, sCol int) IntVec2 {
-	aPos, finished := cursor.anim.GetCurrentStep(float32(singleton.time.delta))
+// position. Grid may be nil if the cursor is not in a grid yet. Sets
+// cursor.needsDraw to false when an animation finished.
+func (cursor *Cursor) animPosition(grid *Grid) IntVec2 {
+	aPos, finished := cursor.anim.GetCurrentStep(float32(singleton.time.animDelta))
 	if finished {
 		cursor.needsDraw = false
-		return IntVec2{
-			X: singleton.cellWidth * (sCol + cursor.Y),
-			Y: singleton.cellHeight * (sRow + cursor.X),
-		}
-	} else {
-		return IntVec2{
-			X: int(float32(singleton.cellWidth) * (float32(sCol) + aPos.Y)),
-			Y: int(float32(singleton.cellHeight) * (float32(sRow) + aPos.X)),
-		}
+		aPos = F32Vec2{X: float32(cursor.X), Y: float32(cursor.Y)}
+	}
+	return cursor.pixelPos(grid, aPos)
+}
+
+// Returns the rendering position of the row and column of the cursor in the
+// grid, which may be fractional while it moves.
+func (cursor *Cursor) pixelPos(grid *Grid, pos F32Vec2) IntVec2 {
+	origin := cellPos(0, 0)
+	width, height := singleton.cellWidth, singleton.cellHeight
+	if grid != nil {
+		origin = cellPos(grid.sRow, grid.sCol)
+		width, height = grid.cellSize()
+	}
+	return IntVec2{
+		X: int(origin.X + float32(width)*pos.Y),
+		Y: int(origin.Y + float32(height)*pos.X),
 	}
 }
 
@@ -170,12 +265,14 @@ func (cursor *Cursor) Show() {
 func (cursor *Cursor) Hide() {
 	if !cursor.hidden {
 		cursor.hidden = true
-		cursor.vertexData.setCellPos(0, F32Rect{})
+		cursor.clear()
 		singleton.render()
 	}
 }
 
-func (cursor *Cursor) drawWithCell(cell Cell, fg U8Color) {
+// Draws the character of the cell to the cell at index of the vertex data.
+// Scale is the scale of the grid which the cell belongs to.
+func (cursor *Cursor) drawWithCell(storage VertexDataStorage, index int, cell Cell, wide bool, fg U8Color, scale float32) {
 	italic := false
 	bold := false
 	underline := false
@@ -187,38 +284,61 @@ func (cursor *Cursor) drawWithCell(cell Cell, fg U8Color) {
 		underline = attrib.underline
 		strikethrough = attrib.strikethrough
 		if attrib.undercurl {
-			cursor.vertexData.setCellSp(0, fg)
+			storage.setCellSp(index, fg)
 		}
 	}
-	atlas_pos := singleton.renderer.getCharPos(
-		cell.char, italic, bold, underline, strikethrough)
-	if atlas_pos.W > singleton.cellWidth {
-		atlas_pos.W /= 2
-	}
-	cursor.vertexData.setCellTex1(0, atlas_pos)
+	// Cursor is one quad and spans two cells for wide characters.
+	atlas_pos := singleton.renderer.getScaledCharPos(
+		cell.char, wide, italic, bold, underline, strikethrough, scale)
+	storage.setCellTex1(index, atlas_pos)
 }
 
 func (cursor *Cursor) Draw() {
+	if !singleton.cmdline.hidden {
+		// Command line draws it's own cursor.
+		cursor.clear()
+		cursor.needsDraw = false
+		singleton.render()
+		return
+	}
 	if !cursor.hidden {
 		mode_info := singleton.mode.Current()
 		fg, bg := cursor.modeColors(mode_info)
-		// Get global position of the cursor.
-		sRow := 0
-		sCol := 0
 		grid, ok := singleton.gridManager.grids[cursor.grid]
-		if ok {
-			sRow = grid.sRow
-			sCol = grid.sCol
+		if ok && grid.typ == GridTypeExternal {
+			// External window draws it's own cursor.
+			cursor.clear()
+			cursor.needsDraw = false
+			if ext, ok := singleton.externalWindows[grid.id]; ok {
+				ext.drawCursor(grid)
+			}
+			singleton.render()
+			return
+		}
+		pos := cursor.animPosition(grid)
+		wide := ok && cursor.X < grid.rows && grid.isWide(cursor.X, cursor.Y)
+		if !singleton.window.hasfocus {
+			cursor.drawOutline(pos, wide, bg)
+			singleton.render()
+			return
+		}
+		cursor.clear()
+		rect, draw_char := cursor.modeRectangle(pos, wide, mode_info)
+		cursor.effect.move(rect, bg)
+		if cursor.anim.isRunning() {
+			// Tail of the trail follows the cursor slower than the head.
+			t := cursor.anim.progress()
+			tail := cursor.anim.from.plus(cursor.anim.target.minus(cursor.anim.from).multiplyS(t * t))
+			tailRect, _ := cursor.modeRectangle(cursor.pixelPos(grid, tail), wide, mode_info)
+			cursor.effect.drawTrail(tailRect, rect, CURSOR_TRAIL_ALPHA*(1-t), bg)
 		}
-		pos := cursor.animPosition(sRow, sCol)
-		rect, draw_char := cursor.modeRectangle(pos, mode_info)
 		// if the draw_char is true, then the cursor shape is block
 		// if the cursor.needsDraw is false, then the cursor animation is finished and this is the last draw
 		if draw_char && !cursor.needsDraw && ok {
 			cell := grid.getCell(cursor.X, cursor.Y)
-			if cell.char != 0 {
+			if cell.char != 0 && cell.char != WIDE_CHAR_CONTINUATION {
 				// We need to draw cell character to the cursor foreground.
-				cursor.drawWithCell(cell, fg)
+				cursor.drawWithCell(cursor.vertexData, 0, cell, wide, fg, grid.scale())
 			} else {
 				// Clear foreground character of the cursor.
 				cursor.vertexData.setCellTex1(0, IntRect{})
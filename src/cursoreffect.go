@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	CURSOR_EFFECT_NONE    = "none"
+	CURSOR_EFFECT_TRAIL   = "trail"
+	CURSOR_EFFECT_RAILGUN = "railgun"
+	CURSOR_EFFECT_TORPEDO = "torpedo"
+	// No new particles are emitted when there are this many particles.
+	CURSOR_MAX_PARTICLES = 64
+	// Particles emitted for every cell the cursor moves.
+	CURSOR_PARTICLES_PER_CELL = 2
+	// Seconds.
+	CURSOR_PARTICLE_LIFETIME = 0.4
+	// Opacity of the trail when the cursor starts moving.
+	CURSOR_TRAIL_ALPHA = 0.5
+)
+
+var CursorEffects = []string{
+	CURSOR_EFFECT_NONE,
+	CURSOR_EFFECT_TRAIL,
+	CURSOR_EFFECT_RAILGUN,
+	CURSOR_EFFECT_TORPEDO,
+}
+
+type CursorParticle struct {
+	// Center of the particle and it's velocity in pixels.
+	pos      F32Vec2
+	velocity F32Vec2
+	age      float32
+	color    U8Color
+}
+
+// Visual effects of the cursor. The trail is a quad which connects the old and
+// new positions of the cursor while it moves, and the particles are emitted
+// when the cursor moves in insert mode. No vertex data is reserved when the
+// effects are disabled.
+type CursorEffect struct {
+	vertexData VertexDataStorage
+	// First cell of the vertex data is the trail, others are particles.
+	particles []CursorParticle
+	drawn     int
+	// Center of the cursor in the last draw in pixels. Particles are emitted
+	// on the way from the last position to the current one.
+	lastPos    F32Vec2
+	hasLast    bool
+	trailDrawn bool
+}
+
+func (effect *CursorEffect) createVertexData() {
+	effect.vertexData = VertexDataStorage{}
+	effect.drawn = 0
+	effect.trailDrawn = false
+	if singleton.options.cursorEffect != CURSOR_EFFECT_NONE {
+		effect.vertexData = singleton.renderer.reserveVertexData(1 + CURSOR_MAX_PARTICLES)
+	}
+}
+
+func (effect *CursorEffect) enabled(name string) bool {
+	return singleton.options.cursorEffect == name && effect.vertexData.end > effect.vertexData.begin
+}
+
+// Returns true if the particles needs updating in the next frame.
+func (effect *CursorEffect) isAnimating() bool {
+	return len(effect.particles) > 0 || effect.drawn > 0
+}
+
+// Draws the trail between the rectangles. The trail is stretched along the
+// direction that the cursor moves most and has the size of the head on the
+// other direction.
+func (effect *CursorEffect) drawTrail(tail, head F32Rect, alpha float32, color U8Color) {
+	if !effect.enabled(CURSOR_EFFECT_TRAIL) {
+		return
+	}
+	trail := head
+	if (F32Vec2{X: head.X - tail.X, Y: head.Y - tail.Y}).isHorizontal() {
+		trail.X = f32min(tail.X, head.X)
+		trail.W = f32max(tail.X+tail.W, head.X+head.W) - trail.X
+	} else {
+		trail.Y = f32min(tail.Y, head.Y)
+		trail.H = f32max(tail.Y+tail.H, head.Y+head.H) - trail.Y
+	}
+	color.A = uint8(float32(color.A) * f32clamp(alpha, 0, 1))
+	effect.vertexData.setCellPos(0, trail)
+	effect.vertexData.setCellTex1(0, IntRect{})
+	effect.vertexData.setCellFg(0, U8Color{})
+	effect.vertexData.setCellBg(0, color)
+	effect.vertexData.setCellSp(0, U8Color{})
+	effect.trailDrawn = true
+}
+
+func (effect *CursorEffect) clearTrail() {
+	if effect.trailDrawn {
+		effect.vertexData.setCellPos(0, F32Rect{})
+		effect.trailDrawn = false
+	}
+}
+
+// Called with the rectangle of the cursor for every draw. Emits particles if
+// the cursor is moved in insert mode.
+func (effect *CursorEffect) move(rect F32Rect, color U8Color) {
+	pos := F32Vec2{X: rect.X + rect.W/2, Y: rect.Y + rect.H/2}
+	last := effect.lastPos
+	moved := effect.hasLast && pos != last
+	effect.lastPos = pos
+	effect.hasLast = true
+	railgun := effect.enabled(CURSOR_EFFECT_RAILGUN)
+	if !moved || (!railgun && !effect.enabled(CURSOR_EFFECT_TORPEDO)) ||
+		singleton.mode.current_mode_name != "insert" {
+		return
+	}
+	distance := last.distance(pos)
+	direction := pos.minus(last).normalized()
+	speed := float32(singleton.cellHeight) * 4
+	count := max(int(distance/float32(singleton.cellWidth))*CURSOR_PARTICLES_PER_CELL, 1)
+	for i := 0; i < count && len(effect.particles) < CURSOR_MAX_PARTICLES; i++ {
+		particle := CursorParticle{
+			pos:   last.plus(direction.multiplyS(distance * float32(i+1) / float32(count))),
+			color: color,
+		}
+		if railgun {
+			// Particles are thrown sideways from the way of the cursor.
+			side := direction.perpendicular()
+			if rand.Intn(2) == 0 {
+				side = side.multiplyS(-1)
+			}
+			particle.velocity = side.multiplyS(speed * (0.5 + rand.Float32()/2))
+		} else {
+			// Particles are left behind the cursor with a little spread.
+			angle := float64(rand.Float32()-0.5) * math.Pi / 3
+			sin, cos := float32(math.Sin(angle)), float32(math.Cos(angle))
+			back := F32Vec2{
+				X: -(direction.X*cos - direction.Y*sin),
+				Y: -(direction.X*sin + direction.Y*cos),
+			}
+			particle.velocity = back.multiplyS(speed * (0.25 + rand.Float32()/4))
+		}
+		effect.particles = append(effect.particles, particle)
+	}
+}
+
+// Moves the particles and removes the dead ones.
+func (effect *CursorEffect) update() {
+	if !effect.isAnimating() {
+		return
+	}
+	delta := float32(singleton.time.animDelta)
+	alive := effect.particles[:0]
+	for _, particle := range effect.particles {
+		particle.age += delta
+		if particle.age >= CURSOR_PARTICLE_LIFETIME {
+			continue
+		}
+		particle.pos = particle.pos.plus(particle.velocity.multiplyS(delta))
+		// Particles slow down.
+		particle.velocity = particle.velocity.multiplyS(f32max(1-4*delta, 0))
+		alive = append(alive, particle)
+	}
+	effect.particles = alive
+	if effect.vertexData.end == effect.vertexData.begin {
+		return
+	}
+	// Particles shrink and fade out in their lifetime.
+	maxSize := f32max(float32(singleton.cellWidth)/3, 2)
+	for i, particle := range effect.particles {
+		life := 1 - particle.age/CURSOR_PARTICLE_LIFETIME
+		size := f32max(maxSize*life, 1)
+		color := particle.color
+		color.A = uint8(float32(color.A) * life)
+		effect.vertexData.setCellPos(1+i, F32Rect{
+			X: particle.pos.X - size/2,
+			Y: particle.pos.Y - size/2,
+			W: size,
+			H: size,
+		})
+		effect.vertexData.setCellTex1(1+i, IntRect{})
+		effect.vertexData.setCellFg(1+i, U8Color{})
+		effect.vertexData.setCellBg(1+i, color)
+		effect.vertexData.setCellSp(1+i, U8Color{})
+	}
+	for i := len(effect.particles); i < effect.drawn; i++ {
+		effect.vertexData.setCellPos(1+i, F32Rect{})
+	}
+	effect.drawn = len(effect.particles)
+	singleton.render()
+}
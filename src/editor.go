@@ -1,18 +1,30 @@
 package main
 
 import (
+	"math"
 	"time"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
 )
 
+const (
+	// Main loop wakes up at least once in this seconds even if nothing
+	// happens.
+	MAINLOOP_IDLE_TIMEOUT = 1.0
+)
+
 type Options struct {
 	// custom options
 	cursorAnimTime      float32
+	cursorAnimCurve     string
+	cursorEffect        string
+	scrollAnimTime      float32
 	transparency        float32
 	targetTPS           int
 	contextMenuEnabled  bool
 	boxDrawingEnabled   bool
+	ligaturesEnabled    bool
+	synthesisEnabled    bool
 	keyToggleFullscreen string
 	keyIncreaseFontSize string
 	keyDecreaseFontSize string
@@ -47,9 +59,28 @@ type Editor struct {
 	// UIOptions is a struct, holds some user ui uiOptions like guifont.
 	// uioptions.go
 	uiOptions UIOptions
+	// Tabline is the neovim's tabline which is drawn by neoray when
+	// ext_tabline is enabled.
+	// tabline.go
+	tabline Tabline
+	// PopupMenu is the neovim's completion menu which is drawn by neoray
+	// when ext_popupmenu is enabled.
+	// popupmenu.go
+	popupMenu PopupMenu
+	// Cmdline is the neovim's command line which is drawn by neoray when
+	// ext_cmdline is enabled.
+	// cmdline.go
+	cmdline Cmdline
+	// Messages are the neovim's messages which are drawn by neoray when
+	// ext_messages is enabled.
+	// messages.go
+	messages Messages
 	// ContextMenu is the only context menu in this program for right click menu.
 	// contextmenu.go
 	contextMenu ContextMenu
+	// Os windows of the external grids, keyed by grid id.
+	// external.go
+	externalWindows map[int]*ExternalWindow
 	// Neoray options.
 	options Options
 	// Tcp server for singleinstance
@@ -58,23 +89,28 @@ type Editor struct {
 	// If quitRequested is true the program will quit.
 	quitRequested chan bool
 	// Initializing in CreateRenderer
-	// TODO: I am going to implement per grid font size, and these variables will be moved to grid.
+	// Cell size of the font. Zoomed grids have their own cell size, see
+	// Grid.cellSize in zoom.go.
 	cellWidth  int
 	cellHeight int
 	// A variable that we can use for checking whether main loop has begun
 	mainLoopRunning bool
+	// True while the main loop can be woken up by posting an empty event.
+	waitingEvents AtomicBool
 	// Mainloop timing values
 	time struct {
-		ticker   *time.Ticker
 		interval time.Duration
 		lastTick time.Time
 		delta    float64
-		lastUPS  int
+		// Delta time for the animations, never longer than the interval.
+		animDelta float64
+		lastUPS   int
 	}
 }
 
 func (editor *Editor) Initialize() {
-	editor.quitRequested = make(chan bool)
+	// Buffered because the main loop only checks it after waking up.
+	editor.quitRequested = make(chan bool, 1)
 
 	editor.nvim = CreateNvimProcess()
 	editor.nvim.init()
@@ -89,7 +125,12 @@ func (editor *Editor) Initialize() {
 	editor.mode = CreateMode()
 
 	editor.cursor = CreateCursor()
+	editor.tabline = CreateTabline()
+	editor.popupMenu = CreatePopupMenu()
+	editor.cmdline = CreateCmdline()
+	editor.messages = CreateMessages()
 	editor.contextMenu = CreateContextMenu()
+	editor.externalWindows = make(map[int]*ExternalWindow)
 	editor.renderer = CreateRenderer()
 
 	// NOTE: Calling this before other initializations makes startup faster,
@@ -111,10 +152,15 @@ func (editor *Editor) Initialize() {
 func CreateDefaultOptions() Options {
 	return Options{
 		cursorAnimTime:      0.06,
+		cursorAnimCurve:     ANIM_CURVE_EASEOUT,
+		cursorEffect:        CURSOR_EFFECT_NONE,
+		scrollAnimTime:      0.1,
 		transparency:        1,
 		targetTPS:           60,
 		contextMenuEnabled:  true,
 		boxDrawingEnabled:   true,
+		ligaturesEnabled:    true,
+		synthesisEnabled:    true,
 		keyToggleFullscreen: "<F11>",
 		keyIncreaseFontSize: "<C-kPlus>",
 		keyDecreaseFontSize: "<C-kMinus>",
@@ -132,11 +178,8 @@ func (editor *Editor) initGlfw() {
 func (editor *Editor) MainLoop() {
 	// For measuring total time of the program.
 	programBegin := time.Now()
-	// Ticker's interval
+	// Minimum time between two updates
 	editor.time.interval = time.Second / time.Duration(editor.options.targetTPS)
-	// NOTE: Ticker is not working correctly on windows.
-	editor.time.ticker = time.NewTicker(editor.time.interval)
-	defer editor.time.ticker.Stop()
 	// For measuring delta time
 	upsTimer := 0.0
 	updates := 0
@@ -144,43 +187,89 @@ func (editor *Editor) MainLoop() {
 	editor.time.lastTick = time.Now()
 	// Mainloop
 	editor.mainLoopRunning = true
+	editor.waitingEvents.Set(true)
 	for editor.mainLoopRunning {
-		select {
-		case tick := <-editor.time.ticker.C:
-			// Calculate delta time
-			elapsed := tick.Sub(editor.time.lastTick)
-			editor.time.lastTick = tick
-			editor.time.delta = elapsed.Seconds()
-			// Increment counters
-			upsTimer += editor.time.delta
-			updates++
-			// Calculate updates per second
-			if upsTimer >= 1 {
-				editor.time.lastUPS = updates
-				updates = 0
-				upsTimer -= 1
-			}
-			// Update program
-			editor.update()
+		// Sleep until an input arrives, other goroutines wake us up or an
+		// animation needs the next frame.
+		if timeout := editor.nextUpdateTimeout(); timeout > 0 {
+			glfw.WaitEventsTimeout(timeout)
+		} else {
 			glfw.PollEvents()
-			// Check for window close
-			if editor.window.handle.ShouldClose() {
-				// Send quit command to neovim and not quit until neovim quits.
-				editor.window.handle.SetShouldClose(false)
-				go editor.nvim.execCommand("qa")
-			}
+		}
+		select {
 		case <-editor.quitRequested:
 			editor.mainLoopRunning = false
+			continue
+		default:
+		}
+		// Calculate delta time
+		tick := time.Now()
+		elapsed := tick.Sub(editor.time.lastTick)
+		editor.time.lastTick = tick
+		editor.time.delta = elapsed.Seconds()
+		// Animations which are started after a sleep begin from their first
+		// frame.
+		editor.time.animDelta = math.Min(editor.time.delta, editor.time.interval.Seconds())
+		// Increment counters
+		upsTimer += editor.time.delta
+		updates++
+		// Calculate updates per second
+		if upsTimer >= 1 {
+			editor.time.lastUPS = updates
+			updates = 0
+			upsTimer = 0
+		}
+		// Update program
+		editor.update()
+		// Check for window close
+		if editor.window.handle.ShouldClose() {
+			// Send quit command to neovim and not quit until neovim quits.
+			editor.window.handle.SetShouldClose(false)
+			go editor.nvim.execCommand("qa")
+		}
+		// Updates are not more frequent than the target tps.
+		if rest := editor.time.interval - time.Since(tick); rest > 0 {
+			time.Sleep(rest)
 		}
 	}
+	editor.waitingEvents.Set(false)
 	logMessage(LEVEL_TRACE, TYPE_PERFORMANCE, "Program finished. Total execution time:", time.Since(programBegin))
 }
 
+// Returns the seconds until the next update is needed. Zero means there is
+// work to do now, and the main loop doesn't sleep.
+func (editor *Editor) nextUpdateTimeout() float64 {
+	if editor.renderer.drawCall || editor.renderer.fullDrawCall || editor.renderer.renderCall ||
+		editor.nvim.eventReceived.Get() || editor.nvim.optionChanged.Get() ||
+		(editor.server != nil && editor.server.dataReceived.Get()) ||
+		editor.gridManager.isScrolling() || len(editor.gridManager.scrolledCells) > 0 {
+		return 0
+	}
+	timeout := MAINLOOP_IDLE_TIMEOUT
+	if next, ok := editor.cursor.nextUpdate(); ok {
+		timeout = math.Min(timeout, next)
+	}
+	if next, ok := editor.messages.nextUpdate(); ok {
+		timeout = math.Min(timeout, next)
+	}
+	return math.Max(timeout, 0)
+}
+
+// Wakes up the main loop if it is sleeping. Other goroutines must call this
+// after they queued work for the main loop.
+func (editor *Editor) wakeUp() {
+	if editor.waitingEvents.Get() {
+		glfw.PostEmptyEvent()
+	}
+}
+
 func (editor *Editor) update() {
 	// Order is important!
 	handleRedrawEvents()
 	editor.window.update()
 	editor.cursor.update()
+	editor.messages.update()
+	editor.gridManager.updateScroll()
 	editor.renderer.update()
 	editor.nvim.update()
 	if editor.server != nil {
@@ -188,9 +277,8 @@ func (editor *Editor) update() {
 	}
 }
 
-func (editor *Editor) resetTicker() {
+func (editor *Editor) resetInterval() {
 	editor.time.interval = time.Second / time.Duration(editor.options.targetTPS)
-	editor.time.ticker.Reset(editor.time.interval)
 }
 
 // If this function called, the screen will be rendered in current loop.
@@ -225,7 +313,7 @@ func (editor *Editor) debugPrintCell(pos IntVec2) {
 	attrib_id: %d
 	needs_redraw: %t
 	data : %+v`,
-		grid, x, y, string(cell.char), cell.char, cell.char, cell.attribId, cell.needsDraw, vertex)
+		grid, x, y, graphemeString(cell.char), cell.char, cell.char, cell.attribId, cell.needsDraw, vertex)
 }
 
 func (editor *Editor) Shutdown() {
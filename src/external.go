@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// ExternalWindow is an os window for a neovim window which is made external
+// with `nvim_win_set_config(win, {'external': v:true})`. Only available with
+// multigrid. The window shares the opengl context with the main window, and
+// uses the same shader, font atlas and vertex buffer. Cells of the grid are
+// stored at the end of the renderer's vertex data, and only rendered in this
+// window.
+type ExternalWindow struct {
+	handle *glfw.Window
+	grid   int
+	// Neovim window id of the grid.
+	window int
+	// Vertex arrays are not shared between contexts.
+	vao uint32
+	// Cells of the grid row by row, and the cursor at the end.
+	vertexData    VertexDataStorage
+	width, height int
+	hidden        bool
+	mousePos      IntVec2
+}
+
+// Opens an os window for the grid, or shows it if it's already open.
+func openExternalWindow(grid *Grid) {
+	if ext, ok := singleton.externalWindows[grid.id]; ok {
+		ext.window = grid.window
+		if ext.hidden {
+			ext.hidden = false
+			ext.handle.Show()
+		}
+		return
+	}
+	width := max(grid.cols, 1) * singleton.cellWidth
+	height := max(grid.rows, 1) * singleton.cellHeight
+	title := fmt.Sprint(singleton.window.title, " - ", grid.window)
+	handle, err := glfw.CreateWindow(width, height, title, nil, singleton.window.handle)
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NEORAY, "Failed to create external window:", err)
+		return
+	}
+	ext := &ExternalWindow{
+		handle: handle,
+		grid:   grid.id,
+		window: grid.window,
+	}
+	ext.width, ext.height = handle.GetFramebufferSize()
+	handle.MakeContextCurrent()
+	ext.vao = rglCreateVertexArray()
+	singleton.window.handle.MakeContextCurrent()
+	ext.initCallbacks()
+	singleton.externalWindows[grid.id] = ext
+	handle.Show()
+	logMessage(LEVEL_DEBUG, TYPE_NEORAY, "External window created for grid", grid.id)
+	// Grid needs it's own vertex data.
+	singleton.renderer.createVertexData()
+	singleton.fullDraw()
+}
+
+// Closes the os window of the grid if it has one.
+func closeExternalWindow(id int) {
+	ext, ok := singleton.externalWindows[id]
+	if !ok {
+		return
+	}
+	ext.handle.MakeContextCurrent()
+	rglDeleteVertexArray(ext.vao)
+	singleton.window.handle.MakeContextCurrent()
+	ext.handle.Destroy()
+	delete(singleton.externalWindows, id)
+	logMessage(LEVEL_DEBUG, TYPE_NEORAY, "External window closed for grid", id)
+	singleton.renderer.createVertexData()
+	singleton.fullDraw()
+}
+
+func hideExternalWindow(id int) {
+	if ext, ok := singleton.externalWindows[id]; ok && !ext.hidden {
+		ext.hidden = true
+		ext.handle.Hide()
+	}
+}
+
+func (ext *ExternalWindow) initCallbacks() {
+	ext.handle.SetCharCallback(charCallback)
+	ext.handle.SetKeyCallback(keyCallback)
+	ext.handle.SetDropCallback(dropCallback)
+
+	ext.handle.SetCloseCallback(
+		func(w *glfw.Window) {
+			// Window is closed when neovim closes it's window.
+			w.SetShouldClose(false)
+			go singleton.nvim.closeWindow(ext.window)
+		})
+
+	ext.handle.SetFocusCallback(
+		func(w *glfw.Window, focused bool) {
+			// Keys are sent to the current window of neovim.
+			if focused {
+				go singleton.nvim.setCurrentWindow(ext.window)
+			}
+		})
+
+	ext.handle.SetFramebufferSizeCallback(
+		func(w *glfw.Window, width, height int) {
+			ext.width, ext.height = width, height
+			if width > 0 && height > 0 {
+				rows := height / singleton.cellHeight
+				cols := width / singleton.cellWidth
+				singleton.gridManager.requestResize(ext.grid, rows, cols)
+				singleton.render()
+			}
+		})
+
+	ext.handle.SetRefreshCallback(
+		func(w *glfw.Window) {
+			singleton.render()
+		})
+
+	ext.handle.SetMouseButtonCallback(
+		func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+			var buttonCode string
+			switch button {
+			case glfw.MouseButtonLeft:
+				buttonCode = "left"
+			case glfw.MouseButtonRight:
+				// Context menu is only in the main window.
+				buttonCode = "right"
+			case glfw.MouseButtonMiddle:
+				buttonCode = "middle"
+			default:
+				return
+			}
+			actionCode := "press"
+			if action == glfw.Release {
+				actionCode = "release"
+			}
+			row, col := ext.cellAt(ext.mousePos)
+			sendMouseInput(buttonCode, actionCode, lastModifiers, ext.grid, row, col)
+			lastMouseButton = buttonCode
+			lastMouseAction = action
+		})
+
+	ext.handle.SetCursorPosCallback(
+		func(w *glfw.Window, xpos, ypos float64) {
+			ext.mousePos = IntVec2{X: int(xpos), Y: int(ypos)}
+			if lastMouseAction == glfw.Press {
+				row, col := ext.cellAt(ext.mousePos)
+				if ext.grid != lastDragGrid || row != lastDragPos.X || col != lastDragPos.Y {
+					sendMouseInput(lastMouseButton, "drag", lastModifiers, ext.grid, row, col)
+					lastDragGrid = ext.grid
+					lastDragPos.X = row
+					lastDragPos.Y = col
+				}
+			}
+		})
+
+	ext.handle.SetScrollCallback(
+		func(w *glfw.Window, xpos, ypos float64) {
+			action := "up"
+			if ypos < 0 {
+				action = "down"
+			}
+			row, col := ext.cellAt(ext.mousePos)
+			sendMouseInput("wheel", action, lastModifiers, ext.grid, row, col)
+		})
+}
+
+// Returns the row and column of the grid at the position in the window.
+func (ext *ExternalWindow) cellAt(pos IntVec2) (int, int) {
+	return max(pos.Y, 0) / singleton.cellHeight, max(pos.X, 0) / singleton.cellWidth
+}
+
+// Called when neovim resizes the grid. Resizes the os window if it has a
+// different size than the grid.
+func (ext *ExternalWindow) gridResized(grid *Grid) {
+	if ext.height/singleton.cellHeight != grid.rows || ext.width/singleton.cellWidth != grid.cols {
+		ext.handle.SetSize(grid.cols*singleton.cellWidth, grid.rows*singleton.cellHeight)
+	}
+	singleton.renderer.createVertexData()
+	singleton.fullDraw()
+}
+
+func (ext *ExternalWindow) createVertexData() {
+	grid, ok := singleton.gridManager.grids[ext.grid]
+	if !ok {
+		ext.vertexData = VertexDataStorage{}
+		return
+	}
+	ext.vertexData = singleton.renderer.reserveVertexData(grid.rows*grid.cols + 1)
+}
+
+// Draws the changed cells of the grid, or all cells if fullDraw is true.
+// Cells are drawn without shaping.
+func (ext *ExternalWindow) draw(grid *Grid, fullDraw bool) {
+	if ext.vertexData.end-ext.vertexData.begin != grid.rows*grid.cols+1 {
+		// Vertex data is created again before the next draw.
+		return
+	}
+	for x := 0; x < grid.rows; x++ {
+		for y := 0; y < grid.cols; y++ {
+			cell := grid.getCell(x, y)
+			if !fullDraw && !cell.needsDraw {
+				continue
+			}
+			index := x*grid.cols + y
+			if fullDraw {
+				ext.vertexData.setCellPos(index, F32Rect{
+					X: float32(y * singleton.cellWidth),
+					Y: float32(x * singleton.cellHeight),
+					W: float32(singleton.cellWidth),
+					H: float32(singleton.cellHeight),
+				})
+			}
+			attrib := HighlightAttribute{}
+			if cell.attribId > 0 {
+				attrib = singleton.gridManager.attributes[cell.attribId]
+			}
+			ext.vertexData.setCellWithAttrib(index, cell.char, grid.isWide(x, y), attrib)
+			grid.cells[x][y].needsDraw = false
+		}
+	}
+	ext.drawCursor(grid)
+}
+
+// Draws the cursor if it's in this grid, the cursor doesn't animate and blink
+// in external windows.
+func (ext *ExternalWindow) drawCursor(grid *Grid) {
+	index := grid.rows * grid.cols
+	if ext.vertexData.end-ext.vertexData.begin != index+1 {
+		return
+	}
+	cursor := &singleton.cursor
+	if cursor.grid != grid.id || cursor.hidden || !singleton.cmdline.hidden ||
+		cursor.X >= grid.rows || cursor.Y >= grid.cols {
+		ext.vertexData.setCellPos(index, F32Rect{})
+		return
+	}
+	info := singleton.mode.Current()
+	fg, bg := cursor.modeColors(info)
+	wide := grid.isWide(cursor.X, cursor.Y)
+	pos := IntVec2{X: cursor.Y * singleton.cellWidth, Y: cursor.X * singleton.cellHeight}
+	rect, drawChar := cursor.modeRectangle(pos, wide, info)
+	cell := grid.getCell(cursor.X, cursor.Y)
+	if drawChar && cell.char != 0 && cell.char != WIDE_CHAR_CONTINUATION {
+		cursor.drawWithCell(ext.vertexData, index, cell, wide, fg, 1)
+	} else {
+		ext.vertexData.setCellTex1(index, IntRect{})
+		ext.vertexData.setCellSp(index, U8Color{})
+	}
+	ext.vertexData.setCellFg(index, fg)
+	ext.vertexData.setCellBg(index, bg)
+	ext.vertexData.setCellPos(index, rect)
+}
+
+// Renders the external windows, must be called after the vertex data is
+// uploaded.
+func renderExternalWindows() {
+	if len(singleton.externalWindows) == 0 {
+		return
+	}
+	for _, ext := range singleton.externalWindows {
+		if ext.hidden || ext.width <= 0 || ext.height <= 0 {
+			continue
+		}
+		ext.handle.MakeContextCurrent()
+		singleton.renderer.fontAtlas.texture.bind()
+		rglCreateViewport(ext.width, ext.height)
+		rglClearScreen(singleton.gridManager.defaultBg)
+		rglRender(ext.vertexData.begin, ext.vertexData.end-ext.vertexData.begin)
+	}
+	// Projection is a uniform of the shared program and must be restored.
+	singleton.window.handle.MakeContextCurrent()
+	rglCreateViewport(singleton.window.width, singleton.window.height)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"math"
 	"path/filepath"
 
+	tsfont "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
 	"github.com/hismailbulut/neoray/src/caskaydia"
 	"github.com/hismailbulut/neoray/src/fontfinder"
 )
@@ -14,7 +17,10 @@ const (
 
 // If you want to disable a font, just set size to 0.
 type Font struct {
-	size        float32
+	size float32
+	// Width of the cells in points, zero means the advance of the regular
+	// face is used.
+	width       float32
 	name        string
 	regular     *FontFace
 	bold_italic *FontFace
@@ -77,13 +83,13 @@ func CreateFont(fontName string, size float32) (Font, bool) {
 	info := fontfinder.Find(fontName)
 
 	var err error
-	if info.Regular != "" {
-		font.regular, err = CreateFace(info.Regular, size)
+	if info.Regular.Filename != "" {
+		font.regular, err = CreateFace(info.Regular.Filename, info.Regular.Index, size)
 		if err != nil {
 			logMessage(LEVEL_ERROR, TYPE_NEORAY, "Failed to load regular font.", err)
 			return font, false
 		} else {
-			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Regular:", filepath.Base(info.Regular))
+			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Regular:", filepath.Base(info.Regular.Filename), info.Regular.Index)
 			font.name = font.regular.FamilyName()
 			if font.name == "" {
 				font.name = "Unknown Family Name"
@@ -93,42 +99,158 @@ func CreateFont(fontName string, size float32) (Font, bool) {
 		return font, false
 	}
 
-	if info.BoldItalic != "" {
-		font.bold_italic, err = CreateFace(info.BoldItalic, size)
+	if info.BoldItalic.Filename != "" {
+		font.bold_italic, err = CreateFace(info.BoldItalic.Filename, info.BoldItalic.Index, size)
 		if err != nil {
 			logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to load bold italic font.", err)
 		} else {
-			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Bold Italic:", filepath.Base(info.BoldItalic))
+			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Bold Italic:", filepath.Base(info.BoldItalic.Filename), info.BoldItalic.Index)
 		}
 	} else {
 		logMessage(LEVEL_WARN, TYPE_NEORAY, "Font has no bold italic face.")
 	}
 
-	if info.Italic != "" {
-		font.italic, err = CreateFace(info.Italic, size)
+	if info.Italic.Filename != "" {
+		font.italic, err = CreateFace(info.Italic.Filename, info.Italic.Index, size)
 		if err != nil {
 			logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to load italic font.", err)
 		} else {
-			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Italic:", filepath.Base(info.Italic))
+			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Italic:", filepath.Base(info.Italic.Filename), info.Italic.Index)
 		}
 	} else {
 		logMessage(LEVEL_WARN, TYPE_NEORAY, "Font has no italic face.")
 	}
 
-	if info.Bold != "" {
-		font.bold, err = CreateFace(info.Bold, size)
+	if info.Bold.Filename != "" {
+		font.bold, err = CreateFace(info.Bold.Filename, info.Bold.Index, size)
 		if err != nil {
 			logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to load bold font.", err)
 		} else {
-			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Bold:", filepath.Base(info.Bold))
+			logMessage(LEVEL_TRACE, TYPE_NEORAY, "Bold:", filepath.Base(info.Bold.Filename), info.Bold.Index)
 		}
 	} else {
 		logMessage(LEVEL_WARN, TYPE_NEORAY, "Font has no bold face.")
 	}
 
+	font.instantiateStyles()
+	font.synthesizeStyles()
+
 	return font, true
 }
 
+// Creates the missing styles from the variation axes if the font is a
+// variable font. Bold italic is created from the italic face if it has the
+// weight axis, because italics of the variable fonts are mostly separate files.
+func (font *Font) instantiateStyles() {
+	if font.bold == nil {
+		font.bold = font.regular.styleInstance(true, false)
+	}
+	if font.italic == nil {
+		font.italic = font.regular.styleInstance(false, true)
+	}
+	if font.bold_italic == nil && font.italic != nil {
+		font.bold_italic = font.italic.styleInstance(true, false)
+	}
+	if font.bold_italic == nil {
+		font.bold_italic = font.regular.styleInstance(true, true)
+	}
+}
+
+// Creates the styles which are still missing by emboldening and shearing the
+// nearest face. These faces are not used when the font synthesis is disabled.
+func (font *Font) synthesizeStyles() {
+	if font.bold == nil {
+		font.bold = font.regular.synthesize(true, false)
+	}
+	if font.italic == nil {
+		font.italic = font.regular.synthesize(false, true)
+	}
+	if font.bold_italic == nil {
+		if font.italic != nil && !font.italic.isSynthetic() {
+			font.bold_italic = font.italic.synthesize(true, false)
+		} else if font.bold != nil && !font.bold.isSynthetic() {
+			font.bold_italic = font.bold.synthesize(false, true)
+		} else {
+			font.bold_italic = font.regular.synthesize(true, true)
+		}
+	}
+}
+
+// Applies the named instance and the axis coordinates to the faces if the font
+// is a variable font, used by the :I and :V options of the guifont. Coordinates
+// are applied after the instance. Styled faces keep the axes of their styles.
+func (font *Font) setVariations(name string, axes []tsfont.Variation) {
+	variations := []tsfont.Variation{}
+	if name != "" {
+		named, ok := font.regular.namedInstance(name)
+		if !ok {
+			logMessage(LEVEL_WARN, TYPE_NEORAY, "Font", font.name, "has no instance named", name)
+		}
+		variations = append(variations, named...)
+	}
+	variations = append(variations, axes...)
+	if len(variations) == 0 {
+		return
+	}
+	regular, err := font.regular.instance(variations)
+	if err != nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to set variations of", font.name, "err:", err)
+		return
+	}
+	font.regular = regular
+	// Other faces may be separate files, they are kept if they can't have
+	// the variations.
+	styled := func(face *FontFace, style ...ot.Tag) *FontFace {
+		if face == nil {
+			return nil
+		}
+		kept := []tsfont.Variation{}
+	next:
+		for _, variation := range variations {
+			for _, tag := range style {
+				if variation.Tag == tag {
+					continue next
+				}
+			}
+			kept = append(kept, variation)
+		}
+		if instance, err := face.instance(kept); err == nil {
+			return instance
+		}
+		return face
+	}
+	font.italic = styled(font.italic, AXIS_ITALIC, AXIS_SLANT)
+	font.bold = styled(font.bold, AXIS_WEIGHT)
+	font.bold_italic = styled(font.bold_italic, AXIS_WEIGHT, AXIS_ITALIC, AXIS_SLANT)
+	font.calcOffsets()
+}
+
+// Sets the weight of the regular and italic faces if the font is a variable
+// font, used by the :W option of the guifont. Returns false if the font has no
+// weight axis.
+func (font *Font) setWeight(weight int) bool {
+	if weight == 0 {
+		return false
+	}
+	if _, ok := font.regular.axis(AXIS_WEIGHT); !ok {
+		return false
+	}
+	variations := []tsfont.Variation{{Tag: AXIS_WEIGHT, Value: float32(weight)}}
+	regular, err := font.regular.instance(variations)
+	if err != nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to set weight of", font.name, "err:", err)
+		return false
+	}
+	font.regular = regular
+	if font.italic != nil {
+		if italic, err := font.italic.instance(variations); err == nil {
+			font.italic = italic
+		}
+	}
+	font.calcOffsets()
+	return true
+}
+
 func (font *Font) Resize(newsize float32) {
 	if newsize < MINIMUM_FONT_SIZE {
 		newsize = MINIMUM_FONT_SIZE
@@ -146,20 +268,75 @@ func (font *Font) Resize(newsize float32) {
 		font.bold.Resize(newsize)
 	}
 	font.size = newsize
+	font.calcOffsets()
+}
+
+// Makes the bold and/or italic faces default for all text, used by the :b
+// and :i options of the guifont. Nothing changes if the font has no face for
+// the style.
+func (font *Font) setStyle(bold, italic bool) {
+	if !bold && !italic {
+		return
+	}
+	face := font.GetSuitableFace(italic, bold)
+	if face == nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Font", font.name, "has no face for the style, bold:", bold, "italic:", italic)
+		return
+	}
+	if bold && italic {
+		font.italic = face
+		font.bold = face
+	} else if bold {
+		font.italic = font.bold_italic
+		font.bold = face
+	} else {
+		font.bold = font.bold_italic
+		font.italic = face
+	}
+	font.regular = face
+}
+
+// Sets the width of the cells in points. Glyphs are centered in the cells.
+func (font *Font) setWidth(width float32) {
+	font.width = width
+	font.calcOffsets()
+}
+
+// Calculates horizontal offsets of the glyphs for centering them in the
+// cells when the cell width is different from the advance of the faces.
+func (font *Font) calcOffsets() {
+	cellWidth, _ := font.GetCellSize()
+	for _, face := range []*FontFace{font.regular, font.bold_italic, font.italic, font.bold} {
+		if face == nil {
+			continue
+		}
+		face.offset = 0
+		if font.width > 0 {
+			face.offset = (cellWidth - face.advance) / 2
+		}
+	}
 }
 
 // This function returns nil when there is no requested font style
 func (font *Font) GetSuitableFace(italic bool, bold bool) *FontFace {
+	face := font.regular
 	if italic && bold {
-		return font.bold_italic
+		face = font.bold_italic
 	} else if italic {
-		return font.italic
+		face = font.italic
 	} else if bold {
-		return font.bold
+		face = font.bold
+	}
+	if face != nil && face.isSynthetic() && !singleton.options.synthesisEnabled {
+		return nil
 	}
-	return font.regular
+	return face
 }
 
 func (font *Font) GetCellSize() (int, int) {
+	if font.width > 0 {
+		width := int(math.Round(float64(font.width) * singleton.window.dpi / 72))
+		return max(width, 1), font.regular.height
+	}
 	return font.regular.advance, font.regular.height
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"math"
 	"os"
+	"unicode"
 
+	tsfont "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
 	"golang.org/x/image/font/sfnt"
@@ -19,32 +23,84 @@ type FontFace struct {
 	handle     font.Face
 	fontHandle *sfnt.Font
 	buffer     sfnt.Buffer
+	// Raw font data, the shaper parses it again when shaping is needed.
+	data []byte
+	// Index of the font in the data if the data is a collection.
+	index         int
+	shapingHandle *tsfont.Face
+	shapingLoaded bool
+	// Variation axes and named instances if the font is a variable font.
+	axes      []FontAxis
+	instances []FontInstance
+	// Axis coordinates of this face, empty for the default instance.
+	variations []tsfont.Variation
+	// Synthetic styles, used when the font has no face for the style.
+	emboldened bool
+	oblique    bool
+	// Color glyph tables, nil if the font has no color glyphs.
+	colors *ColorTables
 
 	advance int
+	// Horizontal offset of the glyphs in the cells, used when the cell
+	// width is different from the advance.
+	offset  int
 	ascent  int
 	descent int
 	height  int
 
 	size      float64
+	ppem      fixed.Int26_6
 	thickness float32
 }
 
-func CreateFace(fileName string, size float32) (*FontFace, error) {
-	fileData, err := os.ReadFile(fileName)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read file: %s\n", err)
+// Contents of the font files by path. Styles of a font are mostly in the same
+// collection file, and every file is read once while loading the fonts. The
+// cache is cleared after the fonts are loaded, faces keep their own data.
+var fontFileCache = make(map[string][]byte)
+
+// Index is the index of the font in the file if the file is a font
+// collection, otherwise must be zero.
+func CreateFace(fileName string, index int, size float32) (*FontFace, error) {
+	fileData, ok := fontFileCache[fileName]
+	if !ok {
+		var err error
+		fileData, err = os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read file: %s\n", err)
+		}
+		fontFileCache[fileName] = fileData
 	}
-	return CreateFaceFromMem(fileData, size)
+	return createFace(fileData, index, size)
+}
+
+func clearFontFileCache() {
+	fontFileCache = make(map[string][]byte)
 }
 
 func CreateFaceFromMem(data []byte, size float32) (*FontFace, error) {
-	sfont, err := opentype.Parse(data)
+	return createFace(data, 0, size)
+}
+
+func createFace(data []byte, index int, size float32) (*FontFace, error) {
+	// Collection parser also accepts single fonts.
+	collection, err := opentype.ParseCollection(data)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse font data: %s\n", err)
 	}
+	if index < 0 || index >= collection.NumFonts() {
+		return nil, fmt.Errorf("Font index %d is out of range, collection has %d fonts\n", index, collection.NumFonts())
+	}
+	sfont, err := collection.Font(index)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse font %d in collection: %s\n", index, err)
+	}
 	face := FontFace{
 		fontHandle: sfont,
+		data:       data,
+		index:      index,
 	}
+	face.loadVariations()
+	face.loadColorTables()
 	face.Resize(size)
 	return &face, nil
 }
@@ -71,6 +127,8 @@ func (face *FontFace) Resize(newsize float32) {
 		return
 	}
 	face.size = float64(newsize)
+	// Same scale used by the opentype face.
+	face.ppem = fixed.Int26_6(0.5 + face.size*singleton.window.dpi*64/72)
 	face.calcMetrics()
 }
 
@@ -80,6 +138,13 @@ func (face *FontFace) calcMetrics() {
 		logMessage(LEVEL_ERROR, TYPE_NEORAY, "Failed to get font advance!")
 		return
 	}
+	if len(face.variations) > 0 {
+		// Width axis changes the advance.
+		handle := face.shapingFace()
+		if id, ok := handle.NominalGlyph('m'); ok {
+			advance = fixed.Int26_6(handle.HorizontalAdvance(id) * float32(face.ppem) / float32(handle.Upem()))
+		}
+	}
 	face.advance = advance.Floor()
 	metrics := face.handle.Metrics()
 	face.ascent = metrics.Ascent.Ceil()
@@ -95,6 +160,39 @@ func (face *FontFace) ContainsGlyph(char rune) bool {
 	return i != 0 && err == nil
 }
 
+// Returns the table loader of the font for reading the tables which are not
+// supported by the sfnt package. Returns nil if the font can't be loaded.
+func (face *FontFace) loader() *ot.Loader {
+	loaders, err := ot.NewLoaders(bytes.NewReader(face.data))
+	if err != nil || face.index >= len(loaders) {
+		return nil
+	}
+	return loaders[face.index]
+}
+
+// Returns the face used by the shaper. The font is parsed when this function
+// is called first time, and returns nil if the shaper can't parse the font.
+func (face *FontFace) shapingFace() *tsfont.Face {
+	if !face.shapingLoaded {
+		face.shapingLoaded = true
+		// Only the font at the index is parsed, collections may have many
+		// large fonts.
+		err := fmt.Errorf("failed to load the font tables")
+		if loader := face.loader(); loader != nil {
+			var parsed *tsfont.Font
+			parsed, err = tsfont.NewFont(loader)
+			if err == nil {
+				face.shapingHandle = tsfont.NewFace(parsed)
+				face.shapingHandle.SetVariations(face.variations)
+			}
+		}
+		if face.shapingHandle == nil {
+			logMessage(LEVEL_WARN, TYPE_NEORAY, "Shaping is disabled for", face.FamilyName(), "err:", err)
+		}
+	}
+	return face.shapingHandle
+}
+
 // This function renders an undercurl to an empty image and returns it.
 // The undercurl drawing job is done in the shaders.
 func (face *FontFace) renderUndercurl() *image.RGBA {
@@ -469,51 +567,171 @@ func (face *FontFace) drawUnicodeBlockGlyph(char rune) *image.RGBA {
 	return img
 }
 
-// Renders given rune and returns rendered RGBA image.
-// Width of the image is always equal to cellWidth or cellWidth*2
-func (face *FontFace) renderGlyph(char rune) *image.RGBA {
+// Renders given rune and returns rendered RGBA image. If the rune is a
+// grapheme cluster, it is shaped. The combining marks are drawn over the
+// first character if the face can't shape the cluster.
+// Width of the image is cellWidth*2 if wide is true, otherwise cellWidth.
+// Returns true if the image is a color glyph, which must not be tinted.
+func (face *FontFace) renderGlyph(char rune, wide bool) (*image.RGBA, bool) {
+	runes := []rune(graphemeString(char))
 	height := singleton.cellHeight
-	dot := fixed.P(0, height-face.descent)
-	dr, mask, maskp, _, ok := face.handle.Glyph(dot, char)
-	if ok {
-		width := singleton.cellWidth
-		if mask.Bounds().Dx() > width {
-			width *= 2
+	width := singleton.cellWidth
+	if wide {
+		width *= 2
+	}
+	dot := fixed.P(face.offset, height-face.descent)
+	if wide {
+		dot.X *= 2
+	}
+	// Color glyphs are only drawn when the emoji option is set.
+	colored := singleton.uiOptions.emoji
+	if len(runes) > 1 {
+		if img, isColor := face.renderCluster(runes, width, height, dot, colored); img != nil {
+			return img, isColor
 		}
+	}
+	if img, isColor := face.renderColorGlyph(runes[0], width, height, dot, colored); img != nil {
+		return img, isColor
+	}
+	dr, mask, maskp, ok := face.runeMask(dot, runes[0])
+	if ok {
 		if mask.Bounds().Dy() > height {
 			// Center image if the image height is taller than our cell height.
 			maskp = image.Pt(0, (height-mask.Bounds().Dy())/2)
 		}
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
-		draw.DrawMask(img, dr, image.White, image.Point{}, mask, maskp, draw.Over)
-		return img
+		if dr.Min.X < -width/4 || dr.Max.X > width+width/4 {
+			// Glyph is too wide for the cells, like the icons that are
+			// narrow in neovim but wide in the font. Scale it to fit.
+			face.drawScaled(img, dr, mask, maskp)
+		} else {
+			// Small overflows are clipped.
+			draw.DrawMask(img, dr, image.White, image.Point{}, mask, maskp, draw.Over)
+		}
+		face.drawMarks(img, runes[1:], dot.Y)
+		return img, false
+	}
+	return nil, false
+}
+
+// Renders the grapheme cluster by shaping it, so the emoji sequences joined
+// with the zero width joiner are composed and the marks are positioned by the
+// font. Returns nil if the face can't shape the cluster or doesn't have a
+// glyph of it. Returns true if the image is a color glyph.
+func (face *FontFace) renderCluster(runes []rune, width, height int, dot fixed.Point26_6, colored bool) (*image.RGBA, bool) {
+	if face.shapingFace() == nil {
+		return nil, false
+	}
+	glyphs := singleton.renderer.shaper.shapeCluster(face, runes)
+	for _, glyph := range glyphs {
+		if glyph.id == 0 {
+			return nil, false
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	isColor := false
+	for _, glyph := range glyphs {
+		glyphDot := dot.Add(fixed.Point26_6{X: glyph.x, Y: glyph.y})
+		// Bitmap glyphs are square, a single glyph fills the image.
+		box := img.Rect
+		if len(glyphs) > 1 {
+			left := glyphDot.X.Round()
+			box = image.Rect(left, 0, left+height, height)
+		}
+		if drawn, glyphColor := face.drawColorGlyph(img, glyph.id, box, glyphDot, colored); drawn {
+			isColor = isColor || glyphColor
+			continue
+		}
+		dr, mask, ok := face.glyphMask(glyph.id, glyphDot)
+		if ok {
+			draw.DrawMask(img, dr, image.White, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+	return img, isColor
+}
+
+// Draws the glyph mask to the image by scaling it down to the image width.
+// Aspect ratio is preserved and the glyph is centered vertically.
+func (face *FontFace) drawScaled(img *image.RGBA, dr image.Rectangle, mask image.Image, maskp image.Point) {
+	height := img.Rect.Dy()
+	glyph := image.NewRGBA(image.Rect(0, 0, dr.Dx(), height))
+	draw.DrawMask(glyph, dr.Sub(image.Pt(dr.Min.X, 0)), image.White, image.Point{}, mask, maskp, draw.Over)
+	scaledHeight := height * img.Rect.Dx() / dr.Dx()
+	top := (height - scaledHeight) / 2
+	target := image.Rect(0, top, img.Rect.Dx(), top+scaledHeight)
+	draw.ApproxBiLinear.Scale(img, target, glyph, glyph.Rect, draw.Over, nil)
+}
+
+// Draws combining marks to the image. Marks are centered horizontally and
+// their vertical position is determined by the font.
+func (face *FontFace) drawMarks(img *image.RGBA, marks []rune, baseline fixed.Int26_6) {
+	for _, mark := range marks {
+		if mark == 0x200D {
+			// Zero width joiner. The face can't shape the joined sequence,
+			// only the first character is drawn.
+			break
+		}
+		if !unicode.In(mark, unicode.Mn, unicode.Me, unicode.Mc) {
+			// Variation selectors, emoji modifiers etc.
+			continue
+		}
+		dr, mask, maskp, ok := face.runeMask(fixed.Point26_6{Y: baseline}, mark)
+		if ok {
+			dr = dr.Add(image.Pt((img.Rect.Dx()-dr.Dx())/2-dr.Min.X, 0))
+			draw.DrawMask(img, dr, image.White, image.Point{}, mask, maskp, draw.Over)
+		}
 	}
-	return nil
 }
 
 // Renders given char to an RGBA image and returns.
 // Also renders underline and strikethrough if specified.
-func (face *FontFace) RenderChar(char rune, underline, strikethrough bool) *image.RGBA {
+// Returns true if the image is a color glyph.
+func (face *FontFace) RenderChar(char rune, wide, underline, strikethrough bool) (*image.RGBA, bool) {
 	if singleton.options.boxDrawingEnabled {
 		if char >= 0x2500 && char <= 0x257F {
 			// Unicode box drawing characters
 			// https://www.compart.com/en/unicode/block/U+2500
 			img := face.drawUnicodeBoxGlyph(char)
 			if img != nil {
-				return img
+				return img, false
 			}
 		} else if char >= 0x2580 && char <= 0x259F {
 			// Unicode block characters
 			// https://www.compart.com/en/unicode/block/U+2580
-			return face.drawUnicodeBlockGlyph(char)
+			return face.drawUnicodeBlockGlyph(char), false
 		}
 	}
 	// Render glyph
-	img := face.renderGlyph(char)
+	img, colored := face.renderGlyph(char, wide)
 	if img == nil {
-		return nil
+		return nil, false
+	}
+	if colored {
+		// Color glyphs are not styled.
+		return img, true
+	}
+	if singleton.uiOptions.parsed.guifont.aliased {
+		aliasImage(img)
+	}
+	face.drawDecorations(img, underline, strikethrough)
+	return img, false
+}
+
+// Removes the antialiasing of the image by making every pixel fully opaque or
+// fully transparent. Images are white and premultiplied, so all channels have
+// the same value.
+func aliasImage(img *image.RGBA) {
+	for i, v := range img.Pix {
+		if v >= 128 {
+			img.Pix[i] = 255
+		} else {
+			img.Pix[i] = 0
+		}
 	}
-	// Draw underline or strikethrough to glyph
+}
+
+// Draws underline or strikethrough to the glyph image.
+func (face *FontFace) drawDecorations(img *image.RGBA, underline, strikethrough bool) {
 	w := float32(img.Rect.Dx())
 	if underline {
 		y := float32(singleton.cellHeight-face.descent) + 1
@@ -523,5 +741,126 @@ func (face *FontFace) RenderChar(char rune, underline, strikethrough bool) *imag
 		y := float32(singleton.cellHeight) / 2
 		drawLine(img, F32Vec2{0, y}, F32Vec2{w, y})
 	}
+}
+
+// Renders the glyphs of a shaped cell to a cell sized image. Positions of the
+// glyphs are relative to the left of the cell, and the parts of the glyphs
+// that are outside of the cell are clipped. Other cells draws these parts.
+func (face *FontFace) RenderShaped(glyphs []CellGlyph, underline, strikethrough bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, singleton.cellWidth, singleton.cellHeight))
+	baseline := fixed.I(singleton.cellHeight - face.descent)
+	for _, glyph := range glyphs {
+		dot := fixed.Point26_6{X: fixed.I(face.offset) + glyph.x, Y: baseline + glyph.y}
+		dr, mask, ok := face.glyphMask(glyph.id, dot)
+		if ok {
+			draw.DrawMask(img, dr, image.White, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+	if singleton.uiOptions.parsed.guifont.aliased {
+		aliasImage(img)
+	}
+	face.drawDecorations(img, underline, strikethrough)
 	return img
 }
+
+// Returns the destination rectangle, mask and mask point of the rune like the
+// font.Face.Glyph. Glyphs of the variable instances and synthetic faces are
+// rasterized from their modified outlines.
+func (face *FontFace) runeMask(dot fixed.Point26_6, char rune) (image.Rectangle, image.Image, image.Point, bool) {
+	if len(face.variations) == 0 && !face.isSynthetic() {
+		dr, mask, maskp, _, ok := face.handle.Glyph(dot, char)
+		return dr, mask, maskp, ok
+	}
+	var id sfnt.GlyphIndex
+	if len(face.variations) > 0 {
+		gid, ok := face.shapingFace().NominalGlyph(char)
+		if !ok {
+			return image.Rectangle{}, nil, image.Point{}, false
+		}
+		id = sfnt.GlyphIndex(gid)
+	} else {
+		var err error
+		id, err = face.fontHandle.GlyphIndex(&face.buffer, char)
+		if err != nil || id == 0 {
+			return image.Rectangle{}, nil, image.Point{}, false
+		}
+	}
+	dr, mask, ok := face.glyphMask(id, dot)
+	if !ok {
+		// Glyph has no outline, like space.
+		return image.Rectangle{}, image.NewAlpha(image.Rectangle{}), image.Point{}, true
+	}
+	return dr, mask, image.Point{}, true
+}
+
+// Loads the outline of the glyph scaled to the ppem. The sfnt package can't
+// apply variations, so outlines of the variable instances are loaded by the
+// shaping face and converted to the same format.
+func (face *FontFace) loadGlyph(id sfnt.GlyphIndex) (sfnt.Segments, error) {
+	if len(face.variations) == 0 {
+		return face.fontHandle.LoadGlyph(&face.buffer, id, face.ppem, nil)
+	}
+	handle := face.shapingFace()
+	outline, ok := handle.GlyphData(tsfont.GID(id)).(tsfont.GlyphOutline)
+	if !ok {
+		return nil, fmt.Errorf("glyph %d has no outline", id)
+	}
+	scale := float32(face.ppem) / float32(handle.Upem())
+	segments := make(sfnt.Segments, len(outline.Segments))
+	for i, seg := range outline.Segments {
+		segments[i].Op = sfnt.SegmentOp(seg.Op)
+		for j, p := range seg.Args {
+			// Font units are y up.
+			segments[i].Args[j] = fixed.Point26_6{
+				X: fixed.Int26_6(p.X * scale),
+				Y: fixed.Int26_6(-p.Y * scale),
+			}
+		}
+	}
+	return segments, nil
+}
+
+// Rasterizes the glyph with given index at the dot and returns the
+// destination rectangle and the mask. This is same as the font.Face.Glyph but
+// takes glyph index instead of a rune, because the shaped glyphs may not have
+// a corresponding rune.
+func (face *FontFace) glyphMask(id sfnt.GlyphIndex, dot fixed.Point26_6) (image.Rectangle, *image.Alpha, bool) {
+	segments, err := face.loadGlyph(id)
+	if err != nil {
+		return image.Rectangle{}, nil, false
+	}
+	if face.isSynthetic() {
+		face.synthesizeOutline(segments)
+	}
+	bounds := segments.Bounds().Add(dot)
+	dr := image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil())
+	if dr.Empty() {
+		return image.Rectangle{}, nil, false
+	}
+	// Convert glyph space to the mask space.
+	bias := dot.Sub(fixed.P(dr.Min.X, dr.Min.Y))
+	point := func(p fixed.Point26_6) (float32, float32) {
+		p = p.Add(bias)
+		return float32(p.X) / 64, float32(p.Y) / 64
+	}
+	r := vector.NewRasterizer(dr.Dx(), dr.Dy())
+	for _, seg := range segments {
+		x0, y0 := point(seg.Args[0])
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			r.MoveTo(x0, y0)
+		case sfnt.SegmentOpLineTo:
+			r.LineTo(x0, y0)
+		case sfnt.SegmentOpQuadTo:
+			x1, y1 := point(seg.Args[1])
+			r.QuadTo(x0, y0, x1, y1)
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := point(seg.Args[1])
+			x2, y2 := point(seg.Args[2])
+			r.CubeTo(x0, y0, x1, y1, x2, y2)
+		}
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	r.Draw(mask, mask.Rect, image.Opaque, image.Point{})
+	return dr, mask, true
+}
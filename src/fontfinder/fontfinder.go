@@ -1,6 +1,7 @@
 package fontfinder
 
 import (
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -9,17 +10,34 @@ import (
 	"unicode"
 
 	"github.com/adrg/sysfont"
+	"golang.org/x/image/font/sfnt"
 )
 
+// FontPath is the location of a font. Index is the index of the font in the
+// file if the file is a font collection (ttc, otc), otherwise zero.
+type FontPath struct {
+	Filename string
+	Index    int
+}
+
 type FontPathInfo struct {
-	Regular    string
-	BoldItalic string
-	Italic     string
-	Bold       string
+	Regular    FontPath
+	BoldItalic FontPath
+	Italic     FontPath
+	Bold       FontPath
+}
+
+// Fonts in the collections are listed separately, and their names are read
+// from the font files.
+type systemFont struct {
+	*sysfont.Font
+	index int
+	// Subfamily name of the font, only set for the fonts in collections.
+	style string
 }
 
 type fontSearchInfo struct {
-	handle    *sysfont.Font
+	handle    systemFont
 	nameWords []string
 	// Style words is not actually styles. But extracted from filename
 	// and removed unneeded stuff and splitted to words. On very wide
@@ -36,7 +54,7 @@ type fontSearchInfo struct {
 
 var (
 	// List of installed system fonts.
-	systemFontList      []*sysfont.Font
+	systemFontList      []systemFont
 	systemFontListReady int32
 
 	// Add more if you know any other filename used in
@@ -56,14 +74,66 @@ func init() {
 	go func() {
 		if systemFontList == nil {
 			finder := sysfont.NewFinder(&sysfont.FinderOpts{
-				Extensions: []string{".ttf", ".otf"},
+				Extensions: []string{".ttf", ".otf", ".ttc", ".otc"},
 			})
-			systemFontList = finder.List()
+			for _, f := range finder.List() {
+				switch strings.ToLower(filepath.Ext(f.Filename)) {
+				case ".ttc", ".otc":
+					systemFontList = append(systemFontList, collectionFonts(f.Filename)...)
+				default:
+					systemFontList = append(systemFontList, systemFont{Font: f})
+				}
+			}
 		}
 		atomic.StoreInt32(&systemFontListReady, 1)
 	}()
 }
 
+// Lists all fonts in the collection with their names. Only the name tables
+// are read from the file.
+func collectionFonts(filename string) []systemFont {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	collection, err := sfnt.ParseCollectionReaderAt(file)
+	if err != nil {
+		return nil
+	}
+	fonts := []systemFont{}
+	var buffer sfnt.Buffer
+	for i := 0; i < collection.NumFonts(); i++ {
+		font, err := collection.Font(i)
+		if err != nil {
+			continue
+		}
+		family, _ := font.Name(&buffer, sfnt.NameIDFamily)
+		style, _ := font.Name(&buffer, sfnt.NameIDSubfamily)
+		name, _ := font.Name(&buffer, sfnt.NameIDFull)
+		if name == "" {
+			name = family + " " + style
+		}
+		fonts = append(fonts, systemFont{
+			Font: &sysfont.Font{
+				Family:   family,
+				Name:     name,
+				Filename: filename,
+			},
+			index: i,
+			style: style,
+		})
+	}
+	return fonts
+}
+
+func (f systemFont) path() FontPath {
+	return FontPath{
+		Filename: f.Filename,
+		Index:    f.index,
+	}
+}
+
 func Find(name string) FontPathInfo {
 	for atomic.LoadInt32(&systemFontListReady) != 1 {
 		time.Sleep(time.Microsecond)
@@ -76,10 +146,16 @@ func find(name string) FontPathInfo {
 	fonts := []fontSearchInfo{}
 
 	for _, f := range systemFontList {
-		if fontContains(f, name) {
+		if fontContains(f.Font, name) {
 			base := filepath.Base(f.Filename)
 			baseWithoutExt := strings.Replace(base, filepath.Ext(base), "", 1)
 			styles := strings.Replace(baseWithoutExt, name, "", 1)
+			if f.style != "" {
+				// Collections have many fonts in the same file and the file
+				// name is not useful, use the names of the font.
+				baseWithoutExt = f.Name
+				styles = f.style
+			}
 			fonts = append(fonts, fontSearchInfo{
 				handle:       f,
 				nameWords:    SplitWords(f.Name),
@@ -104,20 +180,20 @@ func find(name string) FontPathInfo {
 	for _, f := range fonts {
 		// Order is important here.
 		if f.hasItalic && f.hasBold {
-			info.BoldItalic = f.handle.Filename
+			info.BoldItalic = f.handle.path()
 		} else if f.hasItalic {
-			info.Italic = f.handle.Filename
+			info.Italic = f.handle.path()
 		} else if f.hasBold {
-			info.Bold = f.handle.Filename
+			info.Bold = f.handle.path()
 		} else if f.hasRegular {
-			info.Regular = f.handle.Filename
+			info.Regular = f.handle.path()
 			// If a font has 'Regular' string, it is the regular.
 			// No look for others. If no font has 'Regular' or 'Normal'
 			// then the font has smallest filename length and has no
 			// italic or bold will be the regular.
 			regularFounded = true
 		} else if !regularFounded {
-			info.Regular = f.handle.Filename
+			info.Regular = f.handle.path()
 		}
 	}
 
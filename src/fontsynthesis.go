@@ -0,0 +1,143 @@
+package main
+
+import (
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Outlines of the synthetic bold faces are dilated by this fraction of
+	// the em size, same with the FreeType.
+	SYNTHETIC_BOLD_STRENGTH = 1.0 / 24
+	// Horizontal shear of the synthetic oblique faces, about 12 degrees.
+	SYNTHETIC_OBLIQUE_SHEAR = 0.2
+)
+
+// Creates a new face from the same font which draws the glyphs emboldened
+// and/or sheared. Used when the font has no face for the style. Synthetic
+// styles of this face are kept.
+func (face *FontFace) synthesize(bold, oblique bool) *FontFace {
+	synthetic, err := createFace(face.data, face.index, float32(face.size))
+	if err != nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to create synthetic face:", err)
+		return nil
+	}
+	synthetic.variations = face.variations
+	synthetic.emboldened = face.emboldened || bold
+	synthetic.oblique = face.oblique || oblique
+	if len(synthetic.variations) > 0 && synthetic.shapingFace() == nil {
+		return nil
+	}
+	synthetic.calcMetrics()
+	return synthetic
+}
+
+// Returns true if the face has a synthetic style.
+func (face *FontFace) isSynthetic() bool {
+	return face.emboldened || face.oblique
+}
+
+// Applies the synthetic styles of the face to the glyph outline. Outline is
+// modified in place.
+func (face *FontFace) synthesizeOutline(segments sfnt.Segments) {
+	if face.emboldened {
+		emboldenOutline(segments, float32(face.ppem)*SYNTHETIC_BOLD_STRENGTH)
+	}
+	if face.oblique {
+		// Shear around the middle of the ascent, so the glyph stays centered
+		// in the cell.
+		middle := float32(fixed.I(face.ascent)) / 2
+		for i := range segments {
+			for j := range segments[i].Args {
+				p := &segments[i].Args[j]
+				p.X -= fixed.Int26_6((float32(p.Y) + middle) * SYNTHETIC_OBLIQUE_SHEAR)
+			}
+		}
+	}
+}
+
+// Dilates the outline by moving every point along the normals of it's edges,
+// like the FT_Outline_Embolden of the FreeType. Strength is in 26.6 units,
+// and the outline grows by the half of it in every direction.
+func emboldenOutline(segments sfnt.Segments, strength float32) {
+	// Collect the points of every contour, control points are included.
+	var contours [][]*fixed.Point26_6
+	for i := range segments {
+		seg := &segments[i]
+		if seg.Op == sfnt.SegmentOpMoveTo || len(contours) == 0 {
+			contours = append(contours, nil)
+		}
+		count := 1
+		if seg.Op == sfnt.SegmentOpQuadTo {
+			count = 2
+		} else if seg.Op == sfnt.SegmentOpCubeTo {
+			count = 3
+		}
+		last := len(contours) - 1
+		for j := 0; j < count; j++ {
+			contours[last] = append(contours[last], &seg.Args[j])
+		}
+	}
+	// Outer contours of the TrueType and CFF fonts have different directions.
+	// Direction of the whole outline decides which side of the edges is the
+	// outside.
+	var area float32
+	for _, contour := range contours {
+		for i, p := range contour {
+			q := contour[(i+1)%len(contour)]
+			area += float32(p.X)*float32(q.Y) - float32(q.X)*float32(p.Y)
+		}
+	}
+	half := strength / 2
+	if area < 0 {
+		half = -half
+	}
+	vec := func(p *fixed.Point26_6) F32Vec2 {
+		return F32Vec2{X: float32(p.X), Y: float32(p.Y)}
+	}
+	for _, contour := range contours {
+		points := contour
+		// Contours are mostly closed with a point same with the first one,
+		// it is moved together with the first point.
+		closed := len(points) > 1 && *points[0] == *points[len(points)-1]
+		if closed {
+			points = points[:len(points)-1]
+		}
+		count := len(points)
+		if count < 2 {
+			continue
+		}
+		offsets := make([]F32Vec2, count)
+		for i := range points {
+			p := vec(points[i])
+			// Find the nearest different points, contours may have duplicate
+			// points.
+			var in, out F32Vec2
+			for k := 1; k < count && in.length() == 0; k++ {
+				in = p.minus(vec(points[(i-k+count)%count]))
+			}
+			for k := 1; k < count && out.length() == 0; k++ {
+				out = vec(points[(i+k)%count]).minus(p)
+			}
+			if in.length() == 0 || out.length() == 0 {
+				continue
+			}
+			inNormal := in.normalized().perpendicular()
+			outNormal := out.normalized().perpendicular()
+			// Move along the bisector of the normals far enough to move both
+			// edges by the same distance. Very sharp corners are limited.
+			cos := inNormal.dot(outNormal)
+			if cos < -0.9 {
+				cos = -0.9
+			}
+			offsets[i] = inNormal.plus(outNormal).multiplyS(half / (1 + cos))
+		}
+		for i, p := range points {
+			p.X += fixed.Int26_6(offsets[i].X)
+			p.Y += fixed.Int26_6(offsets[i].Y)
+		}
+		if closed {
+			*contour[len(contour)-1] = *contour[0]
+		}
+	}
+}
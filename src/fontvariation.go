@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	tsfont "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// Variation axis tags that we are using for the styles.
+var (
+	AXIS_WEIGHT = ot.MustNewTag("wght")
+	AXIS_ITALIC = ot.MustNewTag("ital")
+	AXIS_SLANT  = ot.MustNewTag("slnt")
+)
+
+const (
+	// Weight of the bold faces created from variable fonts.
+	VARIATION_BOLD_WEIGHT = 700
+)
+
+// A variation axis of a variable font, values are in design units.
+type FontAxis struct {
+	tag     ot.Tag
+	minimum float32
+	def     float32
+	maximum float32
+}
+
+// A named instance of a variable font, like "Bold" or "SemiLight Italic".
+// Coordinates are in the same order with the axes.
+type FontInstance struct {
+	name   string
+	coords []float32
+}
+
+// Parses the fvar table of the font and sets the axes and named instances of
+// the face. Fonts which are not variable have no fvar table.
+func (face *FontFace) loadVariations() {
+	loader := face.loader()
+	if loader == nil || !loader.HasTable(ot.MustNewTag("fvar")) {
+		return
+	}
+	data, err := loader.RawTable(ot.MustNewTag("fvar"))
+	if err != nil {
+		return
+	}
+	axes, instances, err := parseFvar(data)
+	if err != nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to parse variations of", face.FamilyName(), "err:", err)
+		return
+	}
+	face.axes = axes
+	face.instances = make([]FontInstance, 0, len(instances))
+	for _, instance := range instances {
+		name, err := face.fontHandle.Name(&face.buffer, sfnt.NameID(instance.nameID))
+		if err != nil || name == "" {
+			continue
+		}
+		face.instances = append(face.instances, FontInstance{
+			name:   name,
+			coords: instance.coords,
+		})
+	}
+}
+
+type fvarInstance struct {
+	nameID uint16
+	coords []float32
+}
+
+// https://learn.microsoft.com/en-us/typography/opentype/spec/fvar
+func parseFvar(data []byte) ([]FontAxis, []fvarInstance, error) {
+	if len(data) < 16 {
+		return nil, nil, fmt.Errorf("fvar table is too short")
+	}
+	axesOffset := int(binary.BigEndian.Uint16(data[4:]))
+	axisCount := int(binary.BigEndian.Uint16(data[8:]))
+	axisSize := int(binary.BigEndian.Uint16(data[10:]))
+	instanceCount := int(binary.BigEndian.Uint16(data[12:]))
+	instanceSize := int(binary.BigEndian.Uint16(data[14:]))
+	if axisSize < 20 || instanceSize < 4+4*axisCount ||
+		axesOffset+axisCount*axisSize+instanceCount*instanceSize > len(data) {
+		return nil, nil, fmt.Errorf("fvar table is malformed")
+	}
+	fixed := func(b []byte) float32 {
+		return float32(int32(binary.BigEndian.Uint32(b))) / (1 << 16)
+	}
+	axes := make([]FontAxis, axisCount)
+	for i := range axes {
+		record := data[axesOffset+i*axisSize:]
+		axes[i] = FontAxis{
+			tag:     ot.Tag(binary.BigEndian.Uint32(record)),
+			minimum: fixed(record[4:]),
+			def:     fixed(record[8:]),
+			maximum: fixed(record[12:]),
+		}
+	}
+	instances := make([]fvarInstance, instanceCount)
+	instancesOffset := axesOffset + axisCount*axisSize
+	for i := range instances {
+		record := data[instancesOffset+i*instanceSize:]
+		instances[i].nameID = binary.BigEndian.Uint16(record)
+		instances[i].coords = make([]float32, axisCount)
+		for j := range instances[i].coords {
+			instances[i].coords[j] = fixed(record[4+4*j:])
+		}
+	}
+	return axes, instances, nil
+}
+
+// Returns the axis of the face with the tag, and false if the face doesn't
+// have the axis.
+func (face *FontFace) axis(tag ot.Tag) (FontAxis, bool) {
+	for _, axis := range face.axes {
+		if axis.tag == tag {
+			return axis, true
+		}
+	}
+	return FontAxis{}, false
+}
+
+// Returns the variations of the named instance, names are case insensitive.
+func (face *FontFace) namedInstance(name string) ([]tsfont.Variation, bool) {
+	for _, instance := range face.instances {
+		if strings.EqualFold(instance.name, name) {
+			variations := make([]tsfont.Variation, len(face.axes))
+			for i, axis := range face.axes {
+				variations[i] = tsfont.Variation{Tag: axis.tag, Value: instance.coords[i]}
+			}
+			return variations, true
+		}
+	}
+	return nil, false
+}
+
+// Creates a new face from the same font with the given axis coordinates.
+// Coordinates of this face are kept for the axes which are not given.
+func (face *FontFace) instance(variations []tsfont.Variation) (*FontFace, error) {
+	if len(face.axes) == 0 {
+		return nil, fmt.Errorf("%s is not a variable font", face.FamilyName())
+	}
+	merged := append([]tsfont.Variation{}, face.variations...)
+	for _, variation := range variations {
+		if _, ok := face.axis(variation.Tag); !ok {
+			return nil, fmt.Errorf("%s has no %s axis", face.FamilyName(), variation.Tag)
+		}
+		found := false
+		for i := range merged {
+			if merged[i].Tag == variation.Tag {
+				merged[i].Value = variation.Value
+				found = true
+			}
+		}
+		if !found {
+			merged = append(merged, variation)
+		}
+	}
+	instance, err := createFace(face.data, face.index, float32(face.size))
+	if err != nil {
+		return nil, err
+	}
+	instance.variations = merged
+	instance.emboldened = face.emboldened
+	instance.oblique = face.oblique
+	if instance.shapingFace() == nil {
+		// Outlines of the instances are loaded by the shaping face.
+		return nil, fmt.Errorf("failed to parse %s for variations", face.FamilyName())
+	}
+	// Advance may be changed by the variations.
+	instance.calcMetrics()
+	return instance, nil
+}
+
+// Creates a bold and/or italic instance of the face. Named instances are
+// used if the face has them, otherwise bold faces are created with the
+// weight axis and italic faces with the italic or slant axis. Returns nil if
+// the face can't have the style.
+func (face *FontFace) styleInstance(bold, italic bool) *FontFace {
+	if len(face.axes) == 0 || (!bold && !italic) {
+		return nil
+	}
+	name := "Bold Italic"
+	if !italic {
+		name = "Bold"
+	} else if !bold {
+		name = "Italic"
+	}
+	var variations []tsfont.Variation
+	if named, ok := face.namedInstance(name); ok && len(face.variations) == 0 {
+		variations = named
+	} else {
+		if bold {
+			if _, ok := face.axis(AXIS_WEIGHT); !ok {
+				return nil
+			}
+			variations = append(variations, tsfont.Variation{Tag: AXIS_WEIGHT, Value: VARIATION_BOLD_WEIGHT})
+		}
+		if italic {
+			if _, ok := face.axis(AXIS_ITALIC); ok {
+				variations = append(variations, tsfont.Variation{Tag: AXIS_ITALIC, Value: 1})
+			} else if slant, ok := face.axis(AXIS_SLANT); ok {
+				// Negative slant angles lean to the right.
+				variations = append(variations, tsfont.Variation{Tag: AXIS_SLANT, Value: slant.minimum})
+			} else {
+				return nil
+			}
+		}
+	}
+	instance, err := face.instance(variations)
+	if err != nil {
+		logMessage(LEVEL_WARN, TYPE_NEORAY, "Failed to create", name, "instance:", err)
+		return nil
+	}
+	logMessage(LEVEL_TRACE, TYPE_NEORAY, name, "instance of", face.FamilyName(), "created.")
+	return instance
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// A cell may contain more than one code point, like combining characters,
+// emoji sequences and Hangul jamo. Cells store a rune for memory and speed,
+// and the clusters which are more than one code point are interned in the
+// grapheme table. The rune of the cell is an id to the table in this case,
+// which is always bigger than unicode.MaxRune.
+const GRAPHEME_ID_BEGIN = unicode.MaxRune + 1
+
+// Neovim sends an empty text for the right half of the double width
+// characters. These cells are stored with this rune, and the left cell is
+// drawn on both cells.
+const WIDE_CHAR_CONTINUATION rune = -1
+
+// Maximum number of the clusters in the grapheme table.
+const GRAPHEME_TABLE_SIZE = 1 << 16
+
+// Clusters are never removed from the table, because the cells keep their ids.
+// Every unique cluster is stored once and there are not much of them in
+// practice. When the table is full, new clusters are stored as their first
+// code point and their other code points are not drawn.
+var graphemes = struct {
+	clusters []string
+	ids      map[string]rune
+}{
+	ids: make(map[string]rune),
+}
+
+// Returns the rune of the given cell text. If the text is a single code point
+// after normalization, the code point itself is returned. Otherwise the
+// cluster is interned and it's id is returned. Empty text is zero.
+func internGrapheme(str string) rune {
+	if len(str) == 0 {
+		return 0
+	}
+	// Fast path for ascii.
+	if len(str) == 1 {
+		return rune(str[0])
+	}
+	// Composes combining characters and Hangul jamo if possible.
+	str = norm.NFC.String(str)
+	if utf8.RuneCountInString(str) == 1 {
+		r, _ := utf8.DecodeRuneInString(str)
+		return r
+	}
+	if id, ok := graphemes.ids[str]; ok {
+		return id
+	}
+	if len(graphemes.clusters) >= GRAPHEME_TABLE_SIZE {
+		r, _ := utf8.DecodeRuneInString(str)
+		return r
+	}
+	id := GRAPHEME_ID_BEGIN + rune(len(graphemes.clusters))
+	graphemes.clusters = append(graphemes.clusters, str)
+	graphemes.ids[str] = id
+	return id
+}
+
+func isGraphemeCluster(char rune) bool {
+	return char >= GRAPHEME_ID_BEGIN
+}
+
+// Returns the text of the cell rune.
+func graphemeString(char rune) string {
+	if char == WIDE_CHAR_CONTINUATION {
+		return ""
+	} else if isGraphemeCluster(char) {
+		return graphemes.clusters[char-GRAPHEME_ID_BEGIN]
+	}
+	return string(char)
+}
+
+// Returns the first code point of the cluster, which is used for font
+// selection and other character checks.
+func graphemeBase(char rune) rune {
+	if isGraphemeCluster(char) {
+		r, _ := utf8.DecodeRuneInString(graphemes.clusters[char-GRAPHEME_ID_BEGIN])
+		return r
+	}
+	return char
+}
+
+// Returns true if the character needs two cells. This is only used for the
+// texts which are not coming from the grids, grid cells have continuation
+// cells sent by neovim.
+func isWideGrapheme(char rune) bool {
+	switch width.LookupRune(graphemeBase(char)).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return true
+	}
+	return false
+}
+
+// Splits the text to grapheme clusters and returns the interned runes of
+// them. Every returned rune is a cell, and wide characters are followed by
+// a continuation cell like neovim does.
+func splitGraphemes(str string) []rune {
+	chars := make([]rune, 0, len(str))
+	gr := uniseg.NewGraphemes(str)
+	for gr.Next() {
+		char := internGrapheme(gr.Str())
+		chars = append(chars, char)
+		if isWideGrapheme(char) {
+			chars = append(chars, WIDE_CHAR_CONTINUATION)
+		}
+	}
+	return chars
+}
@@ -27,9 +27,10 @@ type HighlightAttribute struct {
 type GridType int32
 
 const (
-	GridTypeNormal  GridType = iota // Normal grid
-	GridTypeMessage                 // Message grid, will be rendered front of the normal grids
-	GridTypeFloat                   // Float window, will be rendered most front
+	GridTypeNormal   GridType = iota // Normal grid
+	GridTypeMessage                  // Message grid, will be rendered front of the normal grids
+	GridTypeFloat                    // Float window, will be rendered most front
+	GridTypeExternal                 // External window, rendered in it's own os window
 )
 
 type Grid struct {
@@ -40,22 +41,45 @@ type Grid struct {
 	rows, cols int // rows and columns of the grid
 	window     int // grid's window id
 	hidden     bool
-	cells      [][]Cell
+	// Floats are drawn in the order of their zindex.
+	zindex    int
+	cells     [][]Cell
+	scrolling GridScroll
+	// Size of the window in the layout, in the cells of the default grid.
+	// Only different from the grid size when the window is zoomed.
+	layoutRows, layoutCols int
+	// Vertex data of the cells when the grid is zoomed.
+	vertexData VertexDataStorage
 }
 
 type GridManager struct {
-	grids       map[int]*Grid
-	attributes  map[int]HighlightAttribute
+	grids      map[int]*Grid
+	attributes map[int]HighlightAttribute
+	// Attribute ids of the builtin highlight groups, like Pmenu and PmenuSel.
+	// Used by ui elements rendered by neoray itself.
+	hlGroups    map[string]int
 	defaultFg   U8Color
 	defaultBg   U8Color
 	defaultSp   U8Color
 	sortedGrids []*Grid
+	// Top grid of every screen cell, cells of the grids under other grids are
+	// not drawn. Updated with every full draw.
+	cellOwners []*Grid
+	// Vertex data of the scrollback rows of the scrolling grids.
+	scrollVertexData VertexDataStorage
+	scrollVertexUsed int
+	// Screen cells which are moved by the smooth scrolling in the last frame.
+	scrolledCells []IntRect
+	// Sizes requested by neoray and the grid resized with the mouse.
+	layout GridLayout
 }
 
 func CreateGridManager() GridManager {
 	grid := GridManager{
 		grids:      make(map[int]*Grid),
 		attributes: make(map[int]HighlightAttribute),
+		hlGroups:   make(map[string]int),
+		layout:     CreateGridLayout(),
 	}
 	return grid
 }
@@ -68,6 +92,8 @@ func (gridType GridType) String() string {
 		return "Message"
 	case GridTypeFloat:
 		return "Float"
+	case GridTypeExternal:
+		return "External"
 	}
 	panic("unknown grid type")
 }
@@ -76,7 +102,7 @@ func (gridType GridType) String() string {
 func (grid *Grid) String() string {
 	return fmt.Sprint("Id: ", grid.id, " Nr: ", grid.number,
 		" Y: ", grid.sRow, " X: ", grid.sCol, " H: ", grid.rows, " W: ", grid.cols,
-		" Win: ", grid.window, " Hidden: ", grid.hidden, " Type: ", grid.typ)
+		" Win: ", grid.window, " Hidden: ", grid.hidden, " Type: ", grid.typ, " Z: ", grid.zindex)
 }
 
 // Sorts grids according to rendering order and returns it.
@@ -107,15 +133,53 @@ func (gridManager *GridManager) sortGrids() []*Grid {
 				if g1.typ < g2.typ {
 					return true
 				}
+				if g1.typ == GridTypeFloat && g1.zindex != g2.zindex {
+					return g1.zindex < g2.zindex
+				}
 				return g1.number < g2.number
 			})
 	}
 	return gridManager.sortedGrids
 }
 
+// Returns the attribute of the builtin highlight group. If neovim didn't send
+// the group, returns an empty attribute which means default colors.
+func (gridManager *GridManager) groupAttrib(name string) HighlightAttribute {
+	if id, ok := gridManager.hlGroups[name]; ok && id > 0 {
+		return gridManager.attributes[id]
+	}
+	return HighlightAttribute{}
+}
+
+// Returns foreground, background and special colors of the attribute.
+func (gridManager *GridManager) attribColors(attrib HighlightAttribute) (U8Color, U8Color, U8Color) {
+	fg := gridManager.defaultFg
+	bg := gridManager.defaultBg
+	sp := gridManager.defaultSp
+	// bg transparency, this only affects default attribute backgrounds
+	bg.A = uint8(singleton.options.transparency * 255)
+	// set attribute colors
+	if attrib.foreground.A > 0 {
+		fg = attrib.foreground
+	}
+	if attrib.background.A > 0 {
+		bg = attrib.background
+	}
+	if attrib.special.A > 0 {
+		sp = attrib.special
+	}
+	// reverse foreground and background
+	if attrib.reverse {
+		fg, bg = bg, fg
+	}
+	return fg, bg, sp
+}
+
 // Returns grid id and cell position at the given global position.
 // The returned values are grid id, cell row, cell column
 func (gridManager *GridManager) getCellAt(pos IntVec2) (int, int, int) {
+	// Grids are placed below the tabline.
+	pos.Y = max(pos.Y-singleton.tabline.height()*singleton.cellHeight, 0)
 	// The input_mouse api call wants 0 for grid when multigrid is not enabled
 	if singleton.parsedArgs.multiGrid == false {
 		return 0, pos.Y / singleton.cellHeight, pos.X / singleton.cellWidth
@@ -124,18 +188,19 @@ func (gridManager *GridManager) getCellAt(pos IntVec2) (int, int, int) {
 	// Find top grid at this position
 	for i := len(gridManager.sortedGrids) - 1; i >= 0; i-- {
 		grid := gridManager.sortedGrids[i]
-		if !grid.hidden {
+		if !grid.hidden && grid.typ != GridTypeExternal {
+			width, height := grid.cellSize()
 			gridRect := IntRect{
 				X: grid.sCol * singleton.cellWidth,
 				Y: grid.sRow * singleton.cellHeight,
-				W: grid.cols * singleton.cellWidth,
-				H: grid.rows * singleton.cellHeight,
+				W: grid.cols * width,
+				H: grid.rows * height,
 			}
 			if pos.inRect(gridRect) {
 				id = grid.id
 				// Calculate cell position
-				row = (pos.Y - gridRect.Y) / singleton.cellHeight
-				col = (pos.X - gridRect.X) / singleton.cellWidth
+				row = (pos.Y - gridRect.Y) / height
+				col = (pos.X - gridRect.X) / width
 				break
 			}
 		}
@@ -143,6 +208,52 @@ func (gridManager *GridManager) getCellAt(pos IntVec2) (int, int, int) {
 	return id, row, col
 }
 
+// Finds the top grid of every screen cell. Grids must be sorted.
+func (gridManager *GridManager) updateCellOwners() {
+	rows, cols := singleton.renderer.rows, singleton.renderer.cols
+	if len(gridManager.cellOwners) != rows*cols {
+		gridManager.cellOwners = make([]*Grid, rows*cols)
+	} else {
+		for i := range gridManager.cellOwners {
+			gridManager.cellOwners[i] = nil
+		}
+	}
+	for _, grid := range gridManager.sortedGrids {
+		if grid.hidden || grid.typ == GridTypeExternal {
+			continue
+		}
+		gridRows, gridCols := grid.screenSize()
+		for x := max(grid.sRow, 0); x < min(grid.sRow+gridRows, rows); x++ {
+			for y := max(grid.sCol, 0); y < min(grid.sCol+gridCols, cols); y++ {
+				gridManager.cellOwners[x*cols+y] = grid
+			}
+		}
+	}
+}
+
+// Returns true if the grid is the top grid at the screen cell, or the owner of
+// the cell is not known.
+func (gridManager *GridManager) ownsCell(grid *Grid, x, y int) bool {
+	cols := singleton.renderer.cols
+	index := x*cols + y
+	if x < 0 || y < 0 || y >= cols || index >= len(gridManager.cellOwners) {
+		return true
+	}
+	owner := gridManager.cellOwners[index]
+	return owner == nil || owner == grid
+}
+
+// Returns true if all cells between left and right of the row of the grid are
+// visible.
+func (grid *Grid) ownsRow(x, left, right int) bool {
+	for y := left; y < right; y++ {
+		if !singleton.gridManager.ownsCell(grid, grid.sRow+x, grid.sCol+y) {
+			return false
+		}
+	}
+	return true
+}
+
 func (gridManager *GridManager) resize(id int, rows, cols int) {
 	grid, ok := gridManager.grids[id]
 	if !ok {
@@ -151,6 +262,14 @@ func (gridManager *GridManager) resize(id int, rows, cols int) {
 		grid.number = len(gridManager.grids)
 		gridManager.grids[id] = grid
 	}
+	delete(gridManager.layout.requested, id)
+	if grid.zoomed() && (rows != grid.rows || cols != grid.cols) {
+		grid.resize(rows, cols)
+		// Zoomed grids have vertex data for their cells.
+		singleton.renderer.createVertexData()
+		singleton.fullDraw()
+		return
+	}
 	grid.resize(rows, cols)
 }
 
@@ -185,12 +304,26 @@ func (grid *Grid) resize(rows, cols int) {
 }
 
 func (grid *Grid) setPos(win, sRow, sCol, rows, cols int, typ GridType) {
+	if grid.typ == GridTypeExternal && typ != GridTypeExternal {
+		// Window is moved back to the main window.
+		closeExternalWindow(grid.id)
+	}
 	grid.window = win
 	grid.typ = typ
 	grid.hidden = false
 
 	grid.sRow = sRow
 	grid.sCol = sCol
+	if typ == GridTypeNormal && grid.id != 1 {
+		grid.layoutRows = rows
+		grid.layoutCols = cols
+		if grid.zoomed() {
+			// Grid size is different from the layout and resized by neovim.
+			singleton.gridManager.fitToLayout(grid)
+			singleton.fullDraw()
+			return
+		}
+	}
 	grid.resize(rows, cols)
 
 	singleton.fullDraw()
@@ -202,6 +335,7 @@ func (gridManager *GridManager) hide(id int) {
 	grid, ok := gridManager.grids[id]
 	if ok {
 		grid.hidden = true
+		hideExternalWindow(id)
 		// NOTE: Hide and destroy functions are only calling when multigrid is on.
 		// When this functions called from neovim, we know which grid is hided or
 		// destroyed but we dont know how many grids affected. Because grids can
@@ -213,9 +347,17 @@ func (gridManager *GridManager) hide(id int) {
 }
 
 func (gridManager *GridManager) destroy(id int) {
-	_, ok := gridManager.grids[id]
+	grid, ok := gridManager.grids[id]
 	if ok {
 		delete(gridManager.grids, id)
+		if grid.zoomed() {
+			singleton.renderer.createVertexData()
+		}
+		delete(gridManager.layout.requested, id)
+		if gridManager.layout.drag.grid == id {
+			gridManager.endDrag()
+		}
+		closeExternalWindow(id)
 		singleton.fullDraw()
 	}
 }
@@ -264,14 +406,42 @@ func (grid *Grid) getCell(x, y int) Cell {
 	return grid.cells[x][y]
 }
 
+// Returns true if the cell is the left half of a double width character,
+// which means the next cell is a continuation cell.
+func (grid *Grid) isWide(x, y int) bool {
+	return y+1 < grid.cols && grid.cells[x][y+1].char == WIDE_CHAR_CONTINUATION
+}
+
+// Returns true if any cell in the first cols of the row needs to be drawn. Shaping of the
+// other cells in the row may be changed by the changed cell, and the whole
+// row is drawn in this case.
+func (grid *Grid) rowNeedsDraw(x, cols int) bool {
+	for y := 0; y < cols; y++ {
+		if grid.cells[x][y].needsDraw {
+			return true
+		}
+	}
+	return false
+}
+
 func (grid *Grid) copyRow(dst, src, left, right int) {
 	copy(grid.cells[dst][left:right], grid.cells[src][left:right])
+	if !grid.inScreenCells() || !grid.ownsRow(dst, left, right) || !grid.ownsRow(src, left, right) {
+		// External and zoomed grids are not in the screen cells, and the
+		// cells under the other grids can't be moved. They are drawn again.
+		for y := left; y < right; y++ {
+			grid.cells[dst][y].needsDraw = true
+		}
+		singleton.draw()
+		return
+	}
 	// Renderer needs global position
 	singleton.renderer.copyRowData(dst+grid.sRow, src+grid.sRow, left+grid.sCol, right+grid.sCol)
 }
 
 func (grid *Grid) scroll(top, bot, rows, left, right int) {
 	defer measure_execution_time()()
+	grid.queueScroll(top, bot, rows, left, right)
 	if rows > 0 { // Scroll down, move up
 		for y := top + rows; y < bot; y++ {
 			grid.copyRow(y-rows, y, left, right)
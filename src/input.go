@@ -148,6 +148,12 @@ func checkNeorayKeybindings(keycode string) bool {
 	case singleton.options.keyToggleFullscreen:
 		singleton.window.toggleFullscreen()
 		return true
+	}
+	// Message history panel uses some keys for scrolling.
+	if singleton.messages.handleKey(keycode) {
+		return true
+	}
+	switch keycode {
 	case "<ESC>":
 		// Hide context menu if esc pressed.
 		if singleton.options.contextMenuEnabled && !singleton.contextMenu.hidden {
@@ -302,6 +308,13 @@ func mouseButtonCallback(w *glfw.Window, button glfw.MouseButton, action glfw.Ac
 	var buttonCode string
 	switch button {
 	case glfw.MouseButtonLeft:
+		// Separators and floats are resized by neoray, not sent to neovim.
+		if action == glfw.Press && singleton.gridManager.beginDrag(lastMousePos, lastModifiers) {
+			return
+		}
+		if action == glfw.Release && singleton.gridManager.endDrag() {
+			return
+		}
 		if action == glfw.Press && singleton.options.contextMenuEnabled {
 			if singleton.contextMenu.mouseClick(false, lastMousePos) {
 				// Mouse clicked to context menu, dont send to neovim.
@@ -332,6 +345,14 @@ func mouseButtonCallback(w *glfw.Window, button glfw.MouseButton, action glfw.Ac
 		return
 	}
 
+	// Tabline handles it's own clicks.
+	if singleton.tabline.contains(lastMousePos) {
+		if action == glfw.Press {
+			singleton.tabline.mouseClick(button, lastMousePos)
+		}
+		return
+	}
+
 	actionCode := "press"
 	if action == glfw.Release {
 		actionCode = "release"
@@ -353,6 +374,11 @@ func cursorPosCallback(w *glfw.Window, xpos, ypos float64) {
 	lastMousePos.X = int(xpos)
 	lastMousePos.Y = int(ypos)
 
+	if singleton.gridManager.updateDrag(lastMousePos) {
+		return
+	}
+	singleton.gridManager.updateMouseCursor(lastMousePos, lastModifiers)
+
 	if singleton.options.contextMenuEnabled {
 		singleton.contextMenu.mouseMove(lastMousePos)
 	}
@@ -376,6 +402,16 @@ func scrollCallback(w *glfw.Window, xpos, ypos float64) {
 		singleton.window.showCursor()
 	}
 
+	// Mouse wheel scrolls the message history panel when it's visible.
+	if !singleton.messages.historyHidden {
+		if ypos < 0 {
+			singleton.messages.ScrollHistory(3)
+		} else {
+			singleton.messages.ScrollHistory(-3)
+		}
+		return
+	}
+
 	action := "up"
 	if ypos < 0 {
 		action = "down"
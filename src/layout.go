@@ -0,0 +1,236 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+type GridDragKind uint8
+
+const (
+	GRID_DRAG_NONE GridDragKind = iota
+	// Separator at the right of the window, changes the width.
+	GRID_DRAG_VERTICAL
+	// Statusline below the window, changes the height.
+	GRID_DRAG_HORIZONTAL
+	// Bottom right corner of a float, changes both.
+	GRID_DRAG_CORNER
+)
+
+// Sizes of the grids are decided by neovim, and positions are sent with the
+// win_pos events. The layout keeps the sizes requested by neoray which are
+// not applied yet, and the grid being resized with the mouse. Only used when
+// multigrid is enabled, the default grid is resized with the window.
+type GridLayout struct {
+	// Requested sizes by grid id, X is rows and Y is columns.
+	requested map[int]IntVec2
+	// Scales of the zoomed windows by window id.
+	zooms map[int]float32
+	drag  struct {
+		kind GridDragKind
+		grid int
+		// Mouse position and the size of the grid when the drag begins.
+		start      IntVec2
+		rows, cols int
+		// Pixels of a row and a column of the dragged size.
+		cellWidth, cellHeight int
+		// Last size requested while dragging.
+		last IntVec2
+	}
+}
+
+func CreateGridLayout() GridLayout {
+	return GridLayout{
+		requested: make(map[int]IntVec2),
+		zooms:     make(map[int]float32),
+	}
+}
+
+// Requests a new size for the grid with nvim_ui_try_resize_grid. Floats are
+// resized, but splits keep their places in the layout and only the grid
+// inside them changes. Neovim may give another size, the grid is resized when
+// the grid_resize event is received.
+func (gridManager *GridManager) requestResize(id, rows, cols int) {
+	grid, ok := gridManager.grids[id]
+	if !ok || id == 1 || rows <= 0 || cols <= 0 {
+		return
+	}
+	size := IntVec2{X: rows, Y: cols}
+	last, pending := gridManager.layout.requested[id]
+	if (pending && last.equals(size)) || (!pending && grid.rows == rows && grid.cols == cols) {
+		return
+	}
+	gridManager.layout.requested[id] = size
+	singleton.nvim.requestGridResize(id, rows, cols)
+}
+
+// Requests the grid size of a split which fits to it's size in the layout.
+// Zoomed splits have less or more cells than the layout, and the request is
+// removed when the zoom is removed.
+func (gridManager *GridManager) fitToLayout(grid *Grid) {
+	if grid.typ != GridTypeNormal || grid.layoutRows <= 0 || grid.layoutCols <= 0 {
+		return
+	}
+	if !grid.zoomed() {
+		if _, ok := gridManager.layout.requested[grid.id]; ok || grid.rows != grid.layoutRows || grid.cols != grid.layoutCols {
+			delete(gridManager.layout.requested, grid.id)
+			singleton.nvim.requestGridResize(grid.id, 0, 0)
+		}
+		return
+	}
+	width, height := grid.cellSize()
+	rows := grid.layoutRows * singleton.cellHeight / height
+	cols := grid.layoutCols * singleton.cellWidth / width
+	gridManager.requestResize(grid.id, max(rows, 1), max(cols, 1))
+}
+
+// Returns the screen cell of the top left corner of the float. Row and column
+// are the position of the anchor corner of the float in the anchor grid, and
+// they may be fractional. Floats are moved into the screen like neovim does.
+func (gridManager *GridManager) floatPos(grid, anchorGrid *Grid, anchor string, row, col float64) (int, int) {
+	// Zoomed anchor grids have their own cells.
+	width, height := anchorGrid.cellSize()
+	sRow := anchorGrid.sRow + int(row*float64(height)/float64(singleton.cellHeight))
+	sCol := anchorGrid.sCol + int(col*float64(width)/float64(singleton.cellWidth))
+	rows, cols := grid.screenSize()
+	if strings.HasPrefix(anchor, "S") {
+		sRow -= rows
+	}
+	if strings.HasSuffix(anchor, "E") {
+		sCol -= cols
+	}
+	sRow = clamp(sRow, 0, max(singleton.renderer.rows-rows, 0))
+	sCol = clamp(sCol, 0, max(singleton.renderer.cols-cols, 0))
+	return sRow, sCol
+}
+
+// Returns what will be resized when the mouse is dragged from this position,
+// and the id of the grid.
+func (gridManager *GridManager) dragTargetAt(pos IntVec2, mods BitMask) (GridDragKind, int) {
+	if !singleton.parsedArgs.multiGrid {
+		return GRID_DRAG_NONE, 0
+	}
+	// Floats are resized by dragging them with alt.
+	if mods.has(ModAlt) {
+		id, _, _ := gridManager.getCellAt(pos)
+		if grid, ok := gridManager.grids[id]; ok && grid.typ == GridTypeFloat {
+			return GRID_DRAG_CORNER, id
+		}
+		return GRID_DRAG_NONE, 0
+	}
+	pos.Y -= singleton.tabline.height() * singleton.cellHeight
+	if pos.X < 0 || pos.Y < 0 {
+		return GRID_DRAG_NONE, 0
+	}
+	row, col := pos.Y/singleton.cellHeight, pos.X/singleton.cellWidth
+	// Separators and statuslines are drawn to the default grid, at the cells
+	// which are not covered by the windows.
+	for _, grid := range gridManager.sortedGrids {
+		if grid.id == 1 || grid.hidden || grid.typ == GridTypeExternal {
+			continue
+		}
+		rows, cols := grid.screenSize()
+		if row >= grid.sRow && row < grid.sRow+rows && col >= grid.sCol && col < grid.sCol+cols {
+			return GRID_DRAG_NONE, 0
+		}
+	}
+	for _, grid := range gridManager.sortedGrids {
+		if grid.id == 1 || grid.hidden || grid.typ != GridTypeNormal {
+			continue
+		}
+		rows, cols := grid.screenSize()
+		if col == grid.sCol+cols && row >= grid.sRow && row < grid.sRow+rows {
+			return GRID_DRAG_VERTICAL, grid.id
+		}
+		if row == grid.sRow+rows && col >= grid.sCol && col < grid.sCol+cols {
+			return GRID_DRAG_HORIZONTAL, grid.id
+		}
+	}
+	return GRID_DRAG_NONE, 0
+}
+
+// Begins resizing a grid if there is something to drag at the position.
+// Returns true if the mouse press is used for resizing.
+func (gridManager *GridManager) beginDrag(pos IntVec2, mods BitMask) bool {
+	kind, id := gridManager.dragTargetAt(pos, mods)
+	if kind == GRID_DRAG_NONE {
+		return false
+	}
+	grid := gridManager.grids[id]
+	drag := &gridManager.layout.drag
+	drag.kind = kind
+	drag.grid = id
+	drag.start = pos
+	// Splits are resized in the layout, and floats in their own cells.
+	drag.rows, drag.cols = grid.screenSize()
+	drag.cellWidth, drag.cellHeight = singleton.cellWidth, singleton.cellHeight
+	if kind == GRID_DRAG_CORNER {
+		drag.rows, drag.cols = grid.rows, grid.cols
+		drag.cellWidth, drag.cellHeight = grid.cellSize()
+	}
+	drag.last = IntVec2{X: drag.rows, Y: drag.cols}
+	return true
+}
+
+// Requests the size of the dragged grid at the new mouse position. Returns
+// false if there is no grid being dragged.
+func (gridManager *GridManager) updateDrag(pos IntVec2) bool {
+	drag := &gridManager.layout.drag
+	if drag.kind == GRID_DRAG_NONE {
+		return false
+	}
+	rows := drag.rows
+	cols := drag.cols
+	if drag.kind != GRID_DRAG_VERTICAL {
+		rows += int(math.Round(float64(pos.Y-drag.start.Y) / float64(drag.cellHeight)))
+	}
+	if drag.kind != GRID_DRAG_HORIZONTAL {
+		cols += int(math.Round(float64(pos.X-drag.start.X) / float64(drag.cellWidth)))
+	}
+	size := IntVec2{X: max(rows, 1), Y: max(cols, 1)}
+	if size.equals(drag.last) {
+		return true
+	}
+	drag.last = size
+	if drag.kind == GRID_DRAG_CORNER {
+		gridManager.requestResize(drag.grid, size.X, size.Y)
+	} else if grid, ok := gridManager.grids[drag.grid]; ok {
+		// Splits are resized in the layout, neovim moves the separators.
+		if drag.kind == GRID_DRAG_VERTICAL {
+			singleton.nvim.setWindowSize(grid.window, 0, size.Y)
+		} else {
+			singleton.nvim.setWindowSize(grid.window, size.X, 0)
+		}
+	}
+	return true
+}
+
+// Returns true if a grid was being dragged.
+func (gridManager *GridManager) endDrag() bool {
+	drag := &gridManager.layout.drag
+	if drag.kind == GRID_DRAG_NONE {
+		return false
+	}
+	drag.kind = GRID_DRAG_NONE
+	return true
+}
+
+// Shows the resize cursor when the mouse is over something draggable.
+func (gridManager *GridManager) updateMouseCursor(pos IntVec2, mods BitMask) {
+	kind := gridManager.layout.drag.kind
+	if kind == GRID_DRAG_NONE {
+		kind, _ = gridManager.dragTargetAt(pos, mods)
+	}
+	switch kind {
+	case GRID_DRAG_VERTICAL:
+		singleton.window.setMouseCursor(glfw.HResizeCursor)
+	case GRID_DRAG_HORIZONTAL:
+		singleton.window.setMouseCursor(glfw.VResizeCursor)
+	case GRID_DRAG_CORNER:
+		singleton.window.setMouseCursor(glfw.CrosshairCursor)
+	default:
+		singleton.window.setMouseCursor(glfw.ArrowCursor)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import "testing"
+
+func Test_dragTargetAt(t *testing.T) {
+	singleton.parsedArgs.multiGrid = true
+	singleton.cellWidth, singleton.cellHeight = 10, 20
+	singleton.tabline.visible = false
+	// Two windows side by side with their statuslines, and a float over the
+	// right window.
+	gridManager := CreateGridManager()
+	gridManager.grids[1] = &Grid{id: 1, rows: 12, cols: 41}
+	gridManager.grids[2] = &Grid{id: 2, number: 1, rows: 10, cols: 20}
+	gridManager.grids[3] = &Grid{id: 3, number: 2, sCol: 21, rows: 10, cols: 20}
+	gridManager.grids[4] = &Grid{id: 4, number: 3, typ: GridTypeFloat, sRow: 2, sCol: 25, rows: 3, cols: 5}
+	gridManager.sortGrids()
+	cell := func(row, col int) IntVec2 {
+		return IntVec2{X: col*10 + 5, Y: row*20 + 5}
+	}
+	tests := []struct {
+		name     string
+		pos      IntVec2
+		mods     BitMask
+		wantKind GridDragKind
+		wantGrid int
+	}{
+		{"Text", cell(3, 3), 0, GRID_DRAG_NONE, 0},
+		{"Vertical separator", cell(4, 20), 0, GRID_DRAG_VERTICAL, 2},
+		{"Statusline", cell(10, 5), 0, GRID_DRAG_HORIZONTAL, 2},
+		{"Right statusline", cell(10, 30), 0, GRID_DRAG_HORIZONTAL, 3},
+		{"Command line", cell(11, 5), 0, GRID_DRAG_NONE, 0},
+		{"Float with alt", cell(3, 27), ModAlt, GRID_DRAG_CORNER, 4},
+		{"Window with alt", cell(3, 3), ModAlt, GRID_DRAG_NONE, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, grid := gridManager.dragTargetAt(tt.pos, tt.mods)
+			if kind != tt.wantKind || grid != tt.wantGrid {
+				t.Errorf("dragTargetAt() = %v, %v, want %v, %v", kind, grid, tt.wantKind, tt.wantGrid)
+			}
+		})
+	}
+}
+
+func Test_zoomedGridCells(t *testing.T) {
+	singleton.parsedArgs.multiGrid = true
+	singleton.cellWidth, singleton.cellHeight = 10, 20
+	singleton.tabline.visible = false
+	singleton.gridManager = CreateGridManager()
+	gridManager := &singleton.gridManager
+	// A split with 10 rows and 20 columns in the layout, zoomed to 2x.
+	gridManager.grids[1] = &Grid{id: 1, rows: 12, cols: 41}
+	gridManager.grids[2] = &Grid{id: 2, number: 1, window: 1000, sRow: 1, sCol: 21,
+		rows: 5, cols: 10, layoutRows: 10, layoutCols: 20}
+	gridManager.layout.zooms[1000] = 2
+	gridManager.sortGrids()
+	grid := gridManager.grids[2]
+	if rows, cols := grid.screenSize(); rows != 10 || cols != 20 {
+		t.Errorf("screenSize() = %v, %v, want 10, 20", rows, cols)
+	}
+	if pos := grid.cellPos(1, 2); pos != (F32Rect{X: 250, Y: 60, W: 20, H: 40}) {
+		t.Errorf("cellPos() = %v", pos)
+	}
+	if id, row, col := gridManager.getCellAt(IntVec2{X: 255, Y: 65}); id != 2 || row != 1 || col != 2 {
+		t.Errorf("getCellAt() = %v, %v, %v, want 2, 1, 2", id, row, col)
+	}
+	if kind, id := gridManager.dragTargetAt(IntVec2{X: 415, Y: 65}, 0); kind != GRID_DRAG_VERTICAL || id != 2 {
+		t.Errorf("dragTargetAt() = %v, %v, want vertical separator of grid 2", kind, id)
+	}
+}
+
+func Test_floatPos(t *testing.T) {
+	singleton.cellWidth, singleton.cellHeight = 10, 20
+	singleton.renderer.rows, singleton.renderer.cols = 20, 80
+	singleton.gridManager = CreateGridManager()
+	anchor := &Grid{id: 2, sRow: 5, sCol: 10, rows: 10, cols: 40}
+	float := &Grid{id: 3, typ: GridTypeFloat, rows: 4, cols: 20}
+	tests := []struct {
+		name     string
+		anchor   string
+		row, col float64
+		wantRow  int
+		wantCol  int
+	}{
+		{"NW", "NW", 1, 2, 6, 12},
+		{"SE", "SE", 8, 30, 9, 20},
+		{"Fractional", "NW", 1.5, 2.75, 6, 12},
+		{"Clipped to the bottom right", "NW", 14, 65, 16, 60},
+		{"Clipped to the top left", "SE", -4, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, col := singleton.gridManager.floatPos(float, anchor, tt.anchor, tt.row, tt.col)
+			if row != tt.wantRow || col != tt.wantCol {
+				t.Errorf("floatPos() = %v, %v, want %v, %v", row, col, tt.wantRow, tt.wantCol)
+			}
+		})
+	}
+}
+
+func Test_updateCellOwners(t *testing.T) {
+	singleton.renderer.rows, singleton.renderer.cols = 10, 20
+	singleton.gridManager = CreateGridManager()
+	gridManager := &singleton.gridManager
+	gridManager.grids[1] = &Grid{id: 1, rows: 10, cols: 20}
+	// Float with the higher zindex is drawn above, even if it's created first.
+	gridManager.grids[2] = &Grid{id: 2, number: 1, typ: GridTypeFloat, zindex: 60, sRow: 2, sCol: 2, rows: 3, cols: 5}
+	gridManager.grids[3] = &Grid{id: 3, number: 2, typ: GridTypeFloat, zindex: 50, sRow: 3, sCol: 4, rows: 3, cols: 5}
+	gridManager.sortGrids()
+	gridManager.updateCellOwners()
+	tests := []struct {
+		x, y int
+		want int
+	}{
+		{0, 0, 1},
+		{2, 2, 2},
+		{3, 5, 2},
+		{5, 5, 3},
+		{3, 8, 3},
+	}
+	for _, tt := range tests {
+		for _, grid := range gridManager.grids {
+			owns := gridManager.ownsCell(grid, tt.x, tt.y)
+			if owns != (grid.id == tt.want) {
+				t.Errorf("ownsCell(%v, %v, %v) = %v, want grid %v", grid.id, tt.x, tt.y, owns, tt.want)
+			}
+		}
+	}
+}
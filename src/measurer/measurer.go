@@ -19,14 +19,35 @@ type function_measure struct {
 	max   time.Duration
 }
 
+type cache_measure struct {
+	hits   int
+	misses int
+}
+
 var (
 	averages map[string]function_measure
+	caches   map[string]cache_measure
 	mutex    sync.Mutex
 )
 
 // Initializes package, only call once at the beginning.
 func Init() {
 	averages = make(map[string]function_measure)
+	caches = make(map[string]cache_measure)
+}
+
+// Counts a hit or miss of the cache with the name. Hit rates of the caches
+// are printed with the function times.
+func Count(name string, hit bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	val := caches[name]
+	if hit {
+		val.hits++
+	} else {
+		val.misses++
+	}
+	caches[name] = val
 }
 
 // This function stores current time and returns a function that does the actual
@@ -109,4 +130,22 @@ func Close() {
 		table.Append([]string{r.name, calls, r.time.String(), average, max})
 	}
 	table.Render()
+
+	if len(caches) == 0 {
+		return
+	}
+	names := make([]string, 0, len(caches))
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table = tw.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CACHE", "HITS", "MISSES", "HIT RATE"})
+	for _, name := range names {
+		val := caches[name]
+		rate := float64(val.hits) / float64(val.hits+val.misses) * 100
+		table.Append([]string{name, strconv.Itoa(val.hits), strconv.Itoa(val.misses), strconv.FormatFloat(rate, 'f', 2, 64) + "%"})
+	}
+	table.Render()
 }
@@ -0,0 +1,358 @@
+package main
+
+import "math"
+
+const (
+	// Toasts are hidden after this time in seconds, error toasts lives twice
+	// as long.
+	MESSAGE_TOAST_TIME = 4
+	// Maximum visible toast count, older toasts are removed.
+	MESSAGE_MAX_TOASTS = 5
+	// Long messages are wrapped at this width.
+	MESSAGE_TOAST_MAX_WIDTH = 60
+)
+
+type Toast struct {
+	kind    string
+	content []TextChunk
+	// Remaining time in seconds. Toasts that waits for user input doesn't
+	// expire and only cleared by msg_clear.
+	remaining float64
+	expires   bool
+}
+
+type messageCell struct {
+	row, col int
+	char     rune
+	attrib   HighlightAttribute
+}
+
+// Messages is the neovim's message area, only used when ext_messages is
+// enabled. Messages are shown as toasts at the bottom right corner of the
+// window, mode and ruler are shown at the last row, and the message history
+// is shown in a scrollable panel.
+type Messages struct {
+	toasts   []Toast
+	showmode []TextChunk
+	showcmd  []TextChunk
+	ruler    []TextChunk
+	// History entries, shown when :messages command is executed.
+	history       [][]TextChunk
+	historyHidden bool
+	// Index of the first visible line of the history panel.
+	historyScroll int
+	// Visible line count of the history panel, calculated when drawing.
+	historyHeight int
+	// Cells are recalculated every time when messages are drawn. The vertex
+	// data only recreated when there are more cells than capacity.
+	cells      []messageCell
+	vertexData VertexDataStorage
+	capacity   int
+}
+
+func CreateMessages() Messages {
+	return Messages{
+		historyHidden: true,
+	}
+}
+
+func (messages *Messages) createVertexData() {
+	messages.vertexData = singleton.renderer.reserveVertexData(messages.capacity)
+	messages.Draw()
+}
+
+// Removes expired toasts.
+func (messages *Messages) update() {
+	if len(messages.toasts) == 0 {
+		return
+	}
+	toasts := messages.toasts[:0]
+	for _, toast := range messages.toasts {
+		if toast.expires {
+			toast.remaining -= singleton.time.delta
+			if toast.remaining <= 0 {
+				continue
+			}
+		}
+		toasts = append(toasts, toast)
+	}
+	if len(toasts) != len(messages.toasts) {
+		messages.toasts = toasts
+		messages.Draw()
+	}
+}
+
+// Returns the seconds until the first toast expires, and false if there is no
+// expiring toast.
+func (messages *Messages) nextUpdate() (float64, bool) {
+	next, ok := 0.0, false
+	for _, toast := range messages.toasts {
+		if toast.expires && (!ok || toast.remaining < next) {
+			next, ok = toast.remaining, true
+		}
+	}
+	return next, ok
+}
+
+func (messages *Messages) Show(kind string, content []TextChunk, replaceLast bool) {
+	if kind == "return_prompt" {
+		// We don't need hit-enter prompts, messages are already visible.
+		return
+	}
+	toast := Toast{
+		kind:      kind,
+		content:   content,
+		remaining: MESSAGE_TOAST_TIME,
+		// Neovim waits for the user in confirm messages.
+		expires: kind != "confirm" && kind != "confirm_sub",
+	}
+	if isErrorMessage(kind) {
+		toast.remaining *= 2
+	}
+	if replaceLast && len(messages.toasts) > 0 {
+		messages.toasts[len(messages.toasts)-1] = toast
+	} else {
+		messages.toasts = append(messages.toasts, toast)
+		if len(messages.toasts) > MESSAGE_MAX_TOASTS {
+			messages.toasts = messages.toasts[1:]
+		}
+	}
+	messages.Draw()
+}
+
+func (messages *Messages) Clear() {
+	if len(messages.toasts) > 0 {
+		messages.toasts = nil
+		messages.Draw()
+	}
+}
+
+func (messages *Messages) SetShowMode(content []TextChunk) {
+	messages.showmode = content
+	messages.Draw()
+}
+
+func (messages *Messages) SetShowCmd(content []TextChunk) {
+	messages.showcmd = content
+	messages.Draw()
+}
+
+func (messages *Messages) SetRuler(content []TextChunk) {
+	messages.ruler = content
+	messages.Draw()
+}
+
+func (messages *Messages) ShowHistory(entries [][]TextChunk) {
+	messages.history = entries
+	messages.historyHidden = false
+	// Scroll is clamped when drawing, this shows the last messages.
+	messages.historyScroll = math.MaxInt32
+	messages.Draw()
+}
+
+func (messages *Messages) HideHistory() {
+	messages.historyHidden = true
+	messages.history = nil
+	messages.Draw()
+}
+
+func (messages *Messages) ScrollHistory(lines int) {
+	messages.historyScroll = max(messages.historyScroll+lines, 0)
+	messages.Draw()
+}
+
+// Handles keys when the history panel is visible. Returns true if the key is
+// used by the panel and must not be sent to neovim. All other keys closes the
+// panel.
+func (messages *Messages) handleKey(keycode string) bool {
+	if messages.historyHidden {
+		return false
+	}
+	switch keycode {
+	case "j", "<Down>":
+		messages.ScrollHistory(1)
+	case "k", "<Up>":
+		messages.ScrollHistory(-1)
+	case "<PageDown>", "<C-f>", "<Space>":
+		messages.ScrollHistory(max(messages.historyHeight-1, 1))
+	case "<PageUp>", "<C-b>":
+		messages.ScrollHistory(-max(messages.historyHeight-1, 1))
+	case "<ESC>", "<CR>", "q":
+		messages.HideHistory()
+	default:
+		messages.HideHistory()
+		return false
+	}
+	return true
+}
+
+func isErrorMessage(kind string) bool {
+	switch kind {
+	case "emsg", "echoerr", "lua_error", "rpc_error":
+		return true
+	}
+	return false
+}
+
+// Returns the border attribute of the message kind.
+func messageBorderAttrib(kind string) HighlightAttribute {
+	if isErrorMessage(kind) {
+		return singleton.gridManager.groupAttrib("ErrorMsg")
+	} else if kind == "wmsg" {
+		return singleton.gridManager.groupAttrib("WarningMsg")
+	}
+	return singleton.gridManager.groupAttrib("FloatBorder")
+}
+
+// Splits contents to lines at newlines and wraps them to the width.
+func messageLines(contents [][]TextChunk, width int) [][]textCell {
+	width = max(width, 1)
+	lines := [][]textCell{}
+	for _, content := range contents {
+		line := []textCell{}
+		for _, cell := range appendChunks(nil, content) {
+			switch cell.char {
+			case '\r':
+				continue
+			case '\t':
+				cell.char = ' '
+			case '\n':
+				lines = append(lines, line)
+				line = []textCell{}
+				continue
+			}
+			if len(line) == width {
+				lines = append(lines, line)
+				line = []textCell{}
+			}
+			line = append(line, cell)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (messages *Messages) addCell(row, col int, char rune, attrib HighlightAttribute) {
+	if char == ' ' {
+		char = 0
+	}
+	messages.cells = append(messages.cells, messageCell{
+		row:    row,
+		col:    col,
+		char:   char,
+		attrib: attrib,
+	})
+}
+
+// Adds given text cells to the cells, starting from the position.
+func (messages *Messages) addText(row, col int, text []textCell, normal HighlightAttribute) {
+	for i, cell := range text {
+		attrib := normal
+		if cell.attribId > 0 {
+			attrib = singleton.gridManager.attributes[cell.attribId]
+		}
+		messages.addCell(row, col+i, cell.char, attrib)
+	}
+}
+
+// Adds a bordered box with given lines. Width and height includes the border.
+func (messages *Messages) addBox(row, col, width, height int, lines [][]textCell, border, normal HighlightAttribute) {
+	for x := 0; x < height; x++ {
+		for y := 0; y < width; y++ {
+			if x == 0 || x == height-1 || y == 0 || y == width-1 {
+				messages.addCell(row+x, col+y, borderChar(x, y, width, height), border)
+			} else if x-1 < len(lines) && y-1 < len(lines[x-1]) {
+				cell := lines[x-1][y-1]
+				attrib := normal
+				if cell.attribId > 0 {
+					attrib = singleton.gridManager.attributes[cell.attribId]
+				}
+				messages.addCell(row+x, col+y, cell.char, attrib)
+			} else {
+				messages.addCell(row+x, col+y, 0, normal)
+			}
+		}
+	}
+}
+
+// Mode is shown at the left side of the last row, showcmd and ruler are
+// shown at the right side.
+func (messages *Messages) addStatus(rows, cols int, normal HighlightAttribute) {
+	row := rows - 1
+	showmode := appendChunks(nil, messages.showmode)
+	messages.addText(row, 0, showmode[:min(len(showmode), cols)], normal)
+	right := appendChunks(nil, messages.showcmd)
+	if len(right) > 0 && len(messages.ruler) > 0 {
+		right = appendText(right, "  ")
+	}
+	right = appendChunks(right, messages.ruler)
+	// Don't overlap with the mode.
+	right = right[:min(len(right), max(cols-len(showmode)-1, 0))]
+	messages.addText(row, cols-len(right), right, normal)
+}
+
+// Toasts are stacked from the bottom right corner, newest one is at the
+// bottom. Toasts that doesn't fit in the window are not shown.
+func (messages *Messages) addToasts(rows, cols int, normal HighlightAttribute) {
+	bottom := rows - 1
+	for i := len(messages.toasts) - 1; i >= 0; i-- {
+		toast := messages.toasts[i]
+		innerWidth := min(MESSAGE_TOAST_MAX_WIDTH, cols-4)
+		lines := messageLines([][]TextChunk{toast.content}, innerWidth)
+		longest := 0
+		for _, line := range lines {
+			longest = max(longest, len(line))
+		}
+		width := max(longest, 1) + 2
+		height := len(lines) + 2
+		row := bottom - height
+		if row < 0 || width > cols {
+			break
+		}
+		messages.addBox(row, cols-width-1, width, height, lines, messageBorderAttrib(toast.kind), normal)
+		bottom = row
+	}
+}
+
+// History panel is placed at the center of the window and it's size is
+// relative to the window.
+func (messages *Messages) addHistory(rows, cols int, normal HighlightAttribute) {
+	width := max(cols*3/4, min(cols, MESSAGE_TOAST_MAX_WIDTH))
+	lines := messageLines(messages.history, width-2)
+	height := min(len(lines)+2, max(rows*2/3, min(rows, 5)))
+	if width < 3 || height < 3 {
+		return
+	}
+	messages.historyHeight = height - 2
+	messages.historyScroll = clamp(messages.historyScroll, 0, max(len(lines)-messages.historyHeight, 0))
+	lines = lines[messages.historyScroll:]
+	messages.addBox((rows-height)/2, (cols-width)/2, width, height, lines,
+		singleton.gridManager.groupAttrib("FloatBorder"), normal)
+}
+
+func (messages *Messages) Draw() {
+	rows := singleton.renderer.rows
+	cols := singleton.renderer.cols
+	normal := singleton.gridManager.groupAttrib("MsgArea")
+	messages.cells = messages.cells[:0]
+	messages.addStatus(rows, cols, normal)
+	messages.addToasts(rows, cols, normal)
+	if !messages.historyHidden {
+		messages.addHistory(rows, cols, normal)
+	}
+	if len(messages.cells) > messages.capacity {
+		messages.capacity = len(messages.cells)
+		// This also draws the messages.
+		singleton.renderer.createVertexData()
+		singleton.fullDraw()
+		return
+	}
+	for i, cell := range messages.cells {
+		messages.vertexData.setCellPos(i, cellPos(cell.row, cell.col))
+		messages.vertexData.setCellWithAttrib(i, cell.char, isWideGrapheme(cell.char), cell.attrib)
+	}
+	for i := len(messages.cells); i < messages.capacity; i++ {
+		messages.vertexData.setCellPos(i, F32Rect{})
+	}
+	singleton.render()
+}
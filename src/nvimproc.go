@@ -13,13 +13,19 @@ import (
 const (
 	// New options
 	OPTION_CURSOR_ANIM    = "CursorAnimTime"
+	OPTION_CURSOR_CURVE   = "CursorAnimCurve"
+	OPTION_CURSOR_EFFECT  = "CursorEffect"
+	OPTION_SCROLL_ANIM    = "ScrollAnimTime"
 	OPTION_TRANSPARENCY   = "Transparency"
 	OPTION_TARGET_TPS     = "TargetTPS"
 	OPTION_CONTEXT_MENU   = "ContextMenuOn"
 	OPTION_CONTEXT_BUTTON = "ContextButton"
 	OPTION_BOX_DRAWING    = "BoxDrawingOn"
+	OPTION_LIGATURES      = "LigaturesOn"
+	OPTION_SYNTHESIS      = "SynthesisOn"
 	OPTION_WINDOW_STATE   = "WindowState"
 	OPTION_WINDOW_SIZE    = "WindowSize"
+	OPTION_GRID_ZOOM      = "GridZoom"
 	// Keybindings
 	OPTION_KEY_FULLSCRN = "KeyFullscreen"
 	OPTION_KEY_ZOOMIN   = "KeyZoomIn"
@@ -29,13 +35,19 @@ const (
 // Add all options here
 var OptionsList = []string{
 	OPTION_CURSOR_ANIM,
+	OPTION_CURSOR_CURVE,
+	OPTION_CURSOR_EFFECT,
+	OPTION_SCROLL_ANIM,
 	OPTION_TRANSPARENCY,
 	OPTION_TARGET_TPS,
 	OPTION_CONTEXT_MENU,
 	OPTION_CONTEXT_BUTTON,
 	OPTION_BOX_DRAWING,
+	OPTION_LIGATURES,
+	OPTION_SYNTHESIS,
 	OPTION_WINDOW_STATE,
 	OPTION_WINDOW_SIZE,
+	OPTION_GRID_ZOOM,
 	OPTION_KEY_FULLSCRN,
 	OPTION_KEY_ZOOMIN,
 	OPTION_KEY_ZOOMOUT,
@@ -158,6 +170,7 @@ func (proc *NvimProcess) registerScripts() {
 			defer proc.optionMutex.Unlock()
 			proc.optionStack = append(proc.optionStack, args)
 			proc.optionChanged.Set(true)
+			singleton.wakeUp()
 		})
 }
 
@@ -165,8 +178,12 @@ func (proc *NvimProcess) startUI(rows, cols int) {
 	defer measure_execution_time()()
 
 	options := map[string]interface{}{
-		"rgb":          true,
-		"ext_linegrid": true,
+		"rgb":           true,
+		"ext_linegrid":  true,
+		"ext_popupmenu": true,
+		"ext_cmdline":   true,
+		"ext_messages":  true,
+		"ext_tabline":   true,
 	}
 
 	if singleton.parsedArgs.multiGrid {
@@ -184,6 +201,7 @@ func (proc *NvimProcess) startUI(rows, cols int) {
 			defer proc.eventMutex.Unlock()
 			proc.eventStack = append(proc.eventStack, updates)
 			proc.eventReceived.Set(true)
+			singleton.wakeUp()
 		})
 
 	go func() {
@@ -193,6 +211,7 @@ func (proc *NvimProcess) startUI(rows, cols int) {
 		}
 		logMessage(LEVEL_TRACE, TYPE_NVIM, "Neovim child process closed.")
 		singleton.quitRequested <- true
+		singleton.wakeUp()
 	}()
 
 	proc.introduce()
@@ -244,6 +263,50 @@ func (proc *NvimProcess) checkOptions() {
 				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_CURSOR_ANIM, "is", opt[1])
 				singleton.options.cursorAnimTime = float32(value)
 				break
+			case OPTION_CURSOR_CURVE:
+				value := strings.ToLower(opt[1])
+				if _, ok := AnimationCurves[value]; !ok {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_CURSOR_CURVE, "value isn't valid.")
+					break
+				}
+				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_CURSOR_CURVE, "is", value)
+				singleton.options.cursorAnimCurve = value
+				break
+			case OPTION_CURSOR_EFFECT:
+				value := strings.ToLower(opt[1])
+				valid := false
+				for _, name := range CursorEffects {
+					valid = valid || name == value
+				}
+				if !valid {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_CURSOR_EFFECT, "value isn't valid.")
+					break
+				}
+				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_CURSOR_EFFECT, "is", value)
+				singleton.options.cursorEffect = value
+				if singleton.mainLoopRunning {
+					// Vertex data of the effects is only reserved when they
+					// are enabled.
+					singleton.renderer.createVertexData()
+					singleton.fullDraw()
+				}
+				break
+			case OPTION_SCROLL_ANIM:
+				value, err := strconv.ParseFloat(opt[1], 32)
+				if err != nil {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_SCROLL_ANIM, "value isn't valid.")
+					break
+				}
+				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_SCROLL_ANIM, "is", opt[1])
+				enabled := singleton.options.scrollAnimTime > 0
+				singleton.options.scrollAnimTime = float32(value)
+				if singleton.mainLoopRunning && enabled != (value > 0) {
+					// Vertex data of the scrollback rows is only reserved
+					// when the animation is enabled.
+					singleton.renderer.createVertexData()
+					singleton.fullDraw()
+				}
+				break
 			case OPTION_TRANSPARENCY:
 				value, err := strconv.ParseFloat(opt[1], 32)
 				if err != nil {
@@ -265,7 +328,7 @@ func (proc *NvimProcess) checkOptions() {
 				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_TARGET_TPS, "is", value)
 				singleton.options.targetTPS = value
 				if singleton.mainLoopRunning {
-					singleton.resetTicker()
+					singleton.resetInterval()
 				}
 				break
 			case OPTION_CONTEXT_MENU:
@@ -302,6 +365,31 @@ func (proc *NvimProcess) checkOptions() {
 					singleton.renderer.clearAtlas()
 				}
 				break
+			case OPTION_LIGATURES:
+				value, err := strconv.ParseBool(opt[1])
+				if err != nil {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_LIGATURES, "value isn't valid.")
+					break
+				}
+				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_LIGATURES, "is", value)
+				singleton.options.ligaturesEnabled = value
+				if singleton.mainLoopRunning {
+					singleton.fullDraw()
+				}
+				break
+			case OPTION_SYNTHESIS:
+				value, err := strconv.ParseBool(opt[1])
+				if err != nil {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_SYNTHESIS, "value isn't valid.")
+					break
+				}
+				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_SYNTHESIS, "is", value)
+				singleton.options.synthesisEnabled = value
+				if singleton.mainLoopRunning {
+					// Supported faces are cached with the atlas.
+					singleton.renderer.clearAtlas()
+				}
+				break
 			case OPTION_WINDOW_STATE:
 				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_WINDOW_STATE, "is", opt[1])
 				singleton.window.setState(opt[1])
@@ -315,6 +403,30 @@ func (proc *NvimProcess) checkOptions() {
 				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_WINDOW_SIZE, "is", width, height)
 				singleton.window.setSize(width, height, true)
 				break
+			case OPTION_GRID_ZOOM:
+				if len(opt) < 3 {
+					logMessage(LEVEL_WARN, TYPE_NVIM, "Not enough argument for option", OPTION_GRID_ZOOM)
+					break
+				}
+				win, err := strconv.Atoi(opt[1])
+				scale, err2 := strconv.ParseFloat(opt[2], 32)
+				if err != nil || err2 != nil || scale < GRID_ZOOM_MIN || scale > GRID_ZOOM_MAX {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_GRID_ZOOM, "value isn't valid.")
+					break
+				}
+				if !singleton.parsedArgs.multiGrid {
+					logMessage(LEVEL_WARN, TYPE_NVIM, OPTION_GRID_ZOOM, "needs multigrid.")
+					break
+				}
+				if win == 0 {
+					win = proc.currentWindow()
+					if win == 0 {
+						break
+					}
+				}
+				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_GRID_ZOOM, "of window", win, "is", scale)
+				singleton.gridManager.setZoom(win, float32(scale))
+				break
 			case OPTION_KEY_FULLSCRN:
 				logMessage(LEVEL_DEBUG, TYPE_NVIM, "Option", OPTION_KEY_FULLSCRN, "is", opt[1])
 				singleton.options.keyToggleFullscreen = opt[1]
@@ -463,6 +575,28 @@ func (proc *NvimProcess) inputMouse(button, action, modifier string, grid, row,
 	}
 }
 
+// Tells neovim how many items are visible in popup menu.
+func (proc *NvimProcess) setPumHeight(height int) {
+	err := proc.handle.SetPumHeight(height)
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to set popup menu height:", err)
+	}
+}
+
+func (proc *NvimProcess) setCurrentTabpage(handle int) {
+	err := proc.handle.SetCurrentTabpage(nvim.Tabpage(handle))
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to set current tabpage:", err)
+	}
+}
+
+func (proc *NvimProcess) setCurrentBuffer(handle int) {
+	err := proc.handle.SetCurrentBuffer(nvim.Buffer(handle))
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to set current buffer:", err)
+	}
+}
+
 func (proc *NvimProcess) requestResize(rows, cols int) {
 	if rows > 0 && cols > 0 {
 		err := proc.handle.TryResizeUI(cols, rows)
@@ -473,6 +607,58 @@ func (proc *NvimProcess) requestResize(rows, cols int) {
 	}
 }
 
+// Requests a new size for the grid. Floating windows are resized, but only the
+// inner grid of a split changes, the layout of the splits stays same. Zero
+// removes the requested size of a split.
+func (proc *NvimProcess) requestGridResize(grid, rows, cols int) {
+	if rows >= 0 && cols >= 0 {
+		err := proc.handle.TryResizeUIGrid(grid, cols, rows)
+		if err != nil {
+			logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to send grid resize request:", err)
+		}
+	}
+}
+
+// Sets the size of a split window in the layout, zero leaves the dimension
+// unchanged.
+func (proc *NvimProcess) setWindowSize(handle, rows, cols int) {
+	if rows > 0 {
+		err := proc.handle.SetWindowHeight(nvim.Window(handle), rows)
+		if err != nil {
+			logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to set window height:", err)
+		}
+	}
+	if cols > 0 {
+		err := proc.handle.SetWindowWidth(nvim.Window(handle), cols)
+		if err != nil {
+			logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to set window width:", err)
+		}
+	}
+}
+
+func (proc *NvimProcess) currentWindow() int {
+	win, err := proc.handle.CurrentWindow()
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to get current window:", err)
+		return 0
+	}
+	return int(win)
+}
+
+func (proc *NvimProcess) setCurrentWindow(handle int) {
+	err := proc.handle.SetCurrentWindow(nvim.Window(handle))
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to set current window:", err)
+	}
+}
+
+func (proc *NvimProcess) closeWindow(handle int) {
+	err := proc.handle.CloseWindow(nvim.Window(handle), false)
+	if err != nil {
+		logMessage(LEVEL_ERROR, TYPE_NVIM, "Failed to close window:", err)
+	}
+}
+
 func (proc *NvimProcess) Close() {
 	// NOTE: We are always trying to close neovim even though it closes itself before us.
 	err := proc.handle.Close()
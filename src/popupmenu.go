@@ -0,0 +1,237 @@
+package main
+
+const (
+	// Maximum visible item count of the popup menu.
+	POPUPMENU_MAX_HEIGHT = 15
+	// Info column is usually long, and we are limiting it.
+	POPUPMENU_MAX_INFO_WIDTH = 40
+)
+
+type PopupMenuItem struct {
+	word string
+	kind string
+	menu string
+	info string
+}
+
+// PopupMenu is the neovim's completion menu and only used when ext_popupmenu
+// option is enabled. Neoray draws it on top of the grids using it's own
+// vertex data, like the context menu.
+type PopupMenu struct {
+	items    []PopupMenuItem
+	selected int
+	// Anchor of the menu. If grid is -1 the menu is for command line.
+	grid, row, col int
+	// Position of the top left corner of the menu in cells.
+	sRow, sCol int
+	// Index of the first visible item.
+	scroll int
+	// Widths of the columns in cells, zero if the column is empty.
+	columns [4]int
+	width   int
+	height  int
+	hidden  bool
+	// Last height sent to neovim, neovim keeps it until another one is sent.
+	sentHeight int
+	// Vertex data may be larger than the menu, and reserved cell count is
+	// stored in capacity. The vertex data only recreated when the menu needs
+	// more cells than capacity.
+	vertexData VertexDataStorage
+	capacity   int
+}
+
+func CreatePopupMenu() PopupMenu {
+	return PopupMenu{
+		hidden:   true,
+		selected: -1,
+	}
+}
+
+func (pmenu *PopupMenu) createVertexData() {
+	pmenu.vertexData = singleton.renderer.reserveVertexData(pmenu.capacity)
+	if !pmenu.hidden {
+		pmenu.Draw()
+	}
+}
+
+func (pmenu *PopupMenu) Show(items []PopupMenuItem, selected, row, col, grid int) {
+	pmenu.items = items
+	pmenu.selected = selected
+	pmenu.grid = grid
+	pmenu.row = row
+	pmenu.col = col
+	pmenu.scroll = 0
+	pmenu.hidden = false
+	pmenu.calcColumns()
+	pmenu.calcPosition()
+	if pmenu.width*pmenu.height > pmenu.capacity {
+		pmenu.capacity = pmenu.width * pmenu.height
+		// This also draws the popup menu.
+		singleton.renderer.createVertexData()
+		singleton.fullDraw()
+		return
+	}
+	pmenu.Draw()
+}
+
+func (pmenu *PopupMenu) Select(selected int) {
+	pmenu.selected = selected
+	if !pmenu.hidden {
+		pmenu.Draw()
+	}
+}
+
+func (pmenu *PopupMenu) Hide() {
+	for i := 0; i < pmenu.capacity; i++ {
+		pmenu.vertexData.setCellPos(i, F32Rect{})
+	}
+	pmenu.hidden = true
+	pmenu.items = nil
+	singleton.render()
+}
+
+// Calculates widths of the word, kind, menu and info columns.
+func (pmenu *PopupMenu) calcColumns() {
+	pmenu.columns = [4]int{}
+	for _, item := range pmenu.items {
+		pmenu.columns[0] = max(pmenu.columns[0], len(splitGraphemes(item.word)))
+		pmenu.columns[1] = max(pmenu.columns[1], len(splitGraphemes(item.kind)))
+		pmenu.columns[2] = max(pmenu.columns[2], len(splitGraphemes(item.menu)))
+		pmenu.columns[3] = max(pmenu.columns[3], len(splitGraphemes(item.info)))
+	}
+	pmenu.columns[3] = min(pmenu.columns[3], POPUPMENU_MAX_INFO_WIDTH)
+	// Every column has one cell space at left, and the last cell of the row
+	// is the scrollbar.
+	pmenu.width = 1
+	for _, w := range pmenu.columns {
+		if w > 0 {
+			pmenu.width += w + 1
+		}
+	}
+}
+
+// Calculates the position and height of the menu. The menu is placed below the
+// anchor if there is enough space, otherwise above. And never overflows the
+// window.
+func (pmenu *PopupMenu) calcPosition() {
+	rows := singleton.renderer.rows
+	cols := singleton.renderer.cols
+	anchorRow := pmenu.row
+	anchorCol := pmenu.col
+	if pmenu.grid == -1 {
+		// Anchored to the command line, row is not used.
+		anchorRow, anchorCol = singleton.cmdline.popupAnchor(pmenu.col)
+	} else if grid, ok := singleton.gridManager.grids[pmenu.grid]; ok {
+		anchorRow, anchorCol = grid.screenCell(anchorRow, anchorCol)
+	}
+	height := min(len(pmenu.items), POPUPMENU_MAX_HEIGHT)
+	below := rows - (anchorRow + 1)
+	above := anchorRow
+	if height <= below || below >= above {
+		pmenu.sRow = anchorRow + 1
+		height = min(height, below)
+	} else {
+		height = min(height, above)
+		pmenu.sRow = anchorRow - height
+	}
+	pmenu.height = max(height, 0)
+	pmenu.width = min(pmenu.width, cols)
+	pmenu.sCol = clamp(anchorCol-1, 0, cols-pmenu.width)
+	// Neovim doesn't accept zero, and the height is only sent when changed
+	// because this is called for every redraw of the menu and the cmdline.
+	// Redraw events are not blocked by waiting for the response.
+	if singleton.mainLoopRunning && pmenu.height > 0 && pmenu.height != pmenu.sentHeight {
+		pmenu.sentHeight = pmenu.height
+		go singleton.nvim.setPumHeight(pmenu.height)
+	}
+}
+
+// Makes sure the selected item is visible.
+func (pmenu *PopupMenu) updateScroll() {
+	if pmenu.selected < 0 {
+		return
+	}
+	if pmenu.selected < pmenu.scroll {
+		pmenu.scroll = pmenu.selected
+	} else if pmenu.selected >= pmenu.scroll+pmenu.height {
+		pmenu.scroll = pmenu.selected - pmenu.height + 1
+	}
+}
+
+// Returns the visible row of the item as cell runes. Length of the returned slice
+// is always equal to width of the menu minus scrollbar.
+func (pmenu *PopupMenu) itemRow(item PopupMenuItem) []rune {
+	row := make([]rune, 0, pmenu.width)
+	texts := [4]string{item.word, item.kind, item.menu, item.info}
+	for i, w := range pmenu.columns {
+		if w == 0 {
+			continue
+		}
+		row = append(row, 0)
+		text := splitGraphemes(texts[i])
+		for j := 0; j < w; j++ {
+			c := rune(0)
+			if j < len(text) && text[j] != ' ' {
+				c = text[j]
+			}
+			row = append(row, c)
+		}
+	}
+	if len(row) > pmenu.width-1 {
+		row = row[:pmenu.width-1]
+	}
+	return row
+}
+
+func (pmenu *PopupMenu) Draw() {
+	if pmenu.hidden {
+		return
+	}
+	pmenu.updateScroll()
+	normal := singleton.gridManager.groupAttrib("Pmenu")
+	selected := singleton.gridManager.groupAttrib("PmenuSel")
+	sbar := singleton.gridManager.groupAttrib("PmenuSbar")
+	thumb := singleton.gridManager.groupAttrib("PmenuThumb")
+	pumblend := clamp(singleton.uiOptions.pumblend, 0, 100)
+	// Calculate scrollbar thumb, only visible when not all items fits.
+	thumbBegin, thumbEnd := 0, 0
+	if len(pmenu.items) > pmenu.height && pmenu.height > 0 {
+		thumbSize := max(pmenu.height*pmenu.height/len(pmenu.items), 1)
+		thumbBegin = pmenu.scroll * (pmenu.height - thumbSize) / (len(pmenu.items) - pmenu.height)
+		thumbEnd = thumbBegin + thumbSize
+	}
+	index := 0
+	for x := 0; x < pmenu.height; x++ {
+		itemIndex := pmenu.scroll + x
+		attrib := normal
+		if itemIndex == pmenu.selected {
+			attrib = selected
+		}
+		row := pmenu.itemRow(pmenu.items[itemIndex])
+		for y := 0; y < pmenu.width; y++ {
+			pmenu.vertexData.setCellPos(index, cellPos(pmenu.sRow+x, pmenu.sCol+y))
+			char, cellAttrib := rune(0), attrib
+			if y < len(row) {
+				char = row[y]
+			} else if thumbEnd > 0 && x >= thumbBegin && x < thumbEnd {
+				cellAttrib = thumb
+			} else if thumbEnd > 0 {
+				cellAttrib = sbar
+			}
+			pmenu.vertexData.setCellWithAttrib(index, char, isWideGrapheme(char), cellAttrib)
+			if pumblend > 0 {
+				// Menu is drawn above the screen cells, the cells under it
+				// are visible through the translucent background.
+				_, bg, _ := singleton.gridManager.attribColors(cellAttrib)
+				bg.A = uint8(int(bg.A) * (100 - pumblend) / 100)
+				pmenu.vertexData.setCellBg(index, bg)
+			}
+			index++
+		}
+	}
+	// Clear unused cells.
+	for ; index < pmenu.capacity; index++ {
+		pmenu.vertexData.setCellPos(index, F32Rect{})
+	}
+	singleton.render()
+}
@@ -5,8 +5,9 @@ import (
 )
 
 var (
-	t_int  = reflect.TypeOf(int(0))
-	t_uint = reflect.TypeOf(uint(0))
+	t_int   = reflect.TypeOf(int(0))
+	t_uint  = reflect.TypeOf(uint(0))
+	t_float = reflect.TypeOf(float64(0))
 )
 
 func handleRedrawEvents() {
@@ -45,6 +46,7 @@ func handleRedrawEvents() {
 				case "visual_bell":
 					break
 				case "flush":
+					singleton.gridManager.flushScrolls()
 					singleton.draw()
 				// Grid Events (line-based)
 				case "grid_resize":
@@ -54,7 +56,7 @@ func handleRedrawEvents() {
 				case "hl_attr_define":
 					hl_attr_define(update[1:])
 				case "hl_group_set":
-					break
+					hl_group_set(update[1:])
 				case "grid_line":
 					grid_line(update[1:])
 				case "grid_clear":
@@ -78,8 +80,45 @@ func handleRedrawEvents() {
 					win_close(update[1:])
 				case "msg_set_pos":
 					msg_set_pos(update[1:])
+				case "msg_show":
+					msg_show(update[1:])
+				case "msg_clear":
+					singleton.messages.Clear()
+				case "msg_showmode":
+					msg_showmode(update[1:])
+				case "msg_showcmd":
+					msg_showcmd(update[1:])
+				case "msg_ruler":
+					msg_ruler(update[1:])
+				case "msg_history_show":
+					msg_history_show(update[1:])
 				case "win_viewport":
 					win_viewport(update[1:])
+				// Popupmenu events
+				case "popupmenu_show":
+					popupmenu_show(update[1:])
+				case "popupmenu_select":
+					popupmenu_select(update[1:])
+				case "popupmenu_hide":
+					singleton.popupMenu.Hide()
+				// Tabline events
+				case "tabline_update":
+					tabline_update(update[1:])
+				// Cmdline events
+				case "cmdline_show":
+					cmdline_show(update[1:])
+				case "cmdline_pos":
+					cmdline_pos(update[1:])
+				case "cmdline_special_char":
+					cmdline_special_char(update[1:])
+				case "cmdline_hide":
+					cmdline_hide(update[1:])
+				case "cmdline_block_show":
+					cmdline_block_show(update[1:])
+				case "cmdline_block_append":
+					cmdline_block_append(update[1:])
+				case "cmdline_block_hide":
+					singleton.cmdline.BlockHide()
 				}
 			}
 		}
@@ -93,6 +132,10 @@ func refToInt(val reflect.Value) int {
 	return int(val.Elem().Convert(t_int).Int())
 }
 
+func refToFloat(val reflect.Value) float64 {
+	return val.Elem().Convert(t_float).Float()
+}
+
 func option_set(args []interface{}) {
 	options := &singleton.uiOptions
 	for _, arg := range args {
@@ -103,19 +146,21 @@ func option_set(args []interface{}) {
 		case "ambiwidth":
 			options.ambiwidth = val.String()
 		case "emoji":
-			options.emoji = val.Bool()
+			options.setEmoji(val.Bool())
 		case "guifont":
 			options.setGuiFont(val.String())
 		case "guifontset":
 			options.guifontset = val.String()
 		case "guifontwide":
-			options.guifontwide = val.String()
+			options.setGuiFontWide(val.String())
 		case "linespace":
 			options.linespace = int(val.Convert(t_int).Int())
 		case "pumblend":
 			options.pumblend = int(val.Convert(t_int).Int())
+			singleton.popupMenu.Draw()
 		case "showtabline":
 			options.showtabline = int(val.Convert(t_int).Int())
+			singleton.tabline.updateVisibility()
 		case "termguicolors":
 			options.termguicolors = val.Bool()
 		}
@@ -179,6 +224,8 @@ func grid_resize(args []interface{}) {
 		// Grid 1 is the default grid for entire screen.
 		if grid == 1 {
 			singleton.renderer.resize(rows, cols)
+		} else if ext, ok := singleton.externalWindows[grid]; ok {
+			ext.gridResized(singleton.gridManager.grids[grid])
 		}
 	}
 }
@@ -244,6 +291,15 @@ func hl_attr_define(args []interface{}) {
 	}
 }
 
+func hl_group_set(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		name := v.Index(0).Elem().String()
+		id := refToInt(v.Index(1))
+		singleton.gridManager.hlGroups[name] = id
+	}
+}
+
 func grid_line(args []interface{}) {
 	for _, arg := range args {
 		v := reflect.ValueOf(arg)
@@ -257,15 +313,17 @@ func grid_line(args []interface{}) {
 			// cell is a slice, may have 1 to 3 elements
 			cellv := reflect.ValueOf(cell)
 			// first one is character
-			var char rune
+			// The text may be more than one code point, and interned as
+			// a grapheme cluster in this case.
 			str := cellv.Index(0).Elem().String()
-			if len(str) > 0 {
-				char = []rune(str)[0]
+			char := internGrapheme(str)
+			if str == "" {
+				// Right half of the double width character.
+				char = WIDE_CHAR_CONTINUATION
+			} else if char == ' ' {
 				// If this is a space, we set it to zero
 				// because otherwise we draw every space
-				if char == ' ' {
-					char = 0
-				}
+				char = 0
 			}
 			// second one is highlight attribute id -optional
 			if cellv.Len() >= 2 {
@@ -350,45 +408,39 @@ func win_float_pos(args []interface{}) {
 		win := refToInt(v.Index(1))
 		anchor := v.Index(2).Elem().String()
 		anchor_grid_id := refToInt(v.Index(3))
-		anchor_row := refToInt(v.Index(4))
-		anchor_col := refToInt(v.Index(5))
+		anchor_row := refToFloat(v.Index(4))
+		anchor_col := refToFloat(v.Index(5))
 		// focusable := v.Index(6).Elem().Bool()
+		// Older versions of neovim doesn't send the zindex.
+		zindex := 0
+		if v.Len() > 7 {
+			zindex = refToInt(v.Index(7))
+		}
 
 		grid, ok := singleton.gridManager.grids[grid_id]
 		anchor_grid, a_ok := singleton.gridManager.grids[anchor_grid_id]
 
 		if ok && a_ok {
-			row := anchor_grid.sRow + anchor_row
-			col := anchor_grid.sCol + anchor_col
-
-			// TODO: This needs to be revisited.
-			switch anchor {
-			case "NW":
-			case "NE":
-				col -= grid.cols
-			case "SW":
-				row -= grid.rows
-			case "SE":
-				col -= grid.cols
-				row -= grid.rows
-			}
-
+			grid.zindex = zindex
+			row, col := singleton.gridManager.floatPos(grid, anchor_grid, anchor, anchor_row, anchor_col)
 			grid.setPos(win, row, col, grid.rows, grid.cols, GridTypeFloat)
 		}
 	}
 }
 
 func win_external_pos(args []interface{}) {
-	// NOTE: Creating an external window needs hard work. Because of this we
-	// are not support external windows for now.
-	/*
-		for _, arg := range args {
-			// Not implemented
-			v := reflect.ValueOf(arg)
-			grid := refToInt(v.Index(0))
-			win := refToInt(v.Index(1))
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		grid_id := refToInt(v.Index(0))
+		win := refToInt(v.Index(1))
+
+		grid, ok := singleton.gridManager.grids[grid_id]
+		if ok {
+			// External grids are drawn from the top left of their windows.
+			grid.setPos(win, 0, 0, grid.rows, grid.cols, GridTypeExternal)
+			openExternalWindow(grid)
 		}
-	*/
+	}
 }
 
 func win_hide(args []interface{}) {
@@ -426,15 +478,212 @@ func msg_set_pos(args []interface{}) {
 }
 
 func win_viewport(args []interface{}) {
-	/*
-		for _, arg := range args {
-			v := reflect.ValueOf(arg)
-			grid := refToInt(v.Index(0))
-			win := refToInt(v.Index(1))
-			topline := refToInt(v.Index(2))
-			botline := refToInt(v.Index(3))
-			curline := refToInt(v.Index(4))
-			curcol := refToInt(v.Index(5))
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		grid_id := refToInt(v.Index(0))
+		// win := refToInt(v.Index(1))
+		topline := refToInt(v.Index(2))
+		// botline := refToInt(v.Index(3))
+		// curline := refToInt(v.Index(4))
+		// curcol := refToInt(v.Index(5))
+		if !singleton.parsedArgs.multiGrid {
+			// Only the current window is reported, and it is drawn on the
+			// default grid.
+			grid_id = 1
+		}
+		grid, ok := singleton.gridManager.grids[grid_id]
+		if ok {
+			grid.setTopline(topline)
+		}
+	}
+}
+
+func popupmenu_show(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		itemsv := v.Index(0).Elem()
+		items := make([]PopupMenuItem, itemsv.Len())
+		for i := 0; i < itemsv.Len(); i++ {
+			// Every item is an array of word, kind, menu and info.
+			item := itemsv.Index(i).Elem()
+			items[i] = PopupMenuItem{
+				word: item.Index(0).Elem().String(),
+				kind: item.Index(1).Elem().String(),
+				menu: item.Index(2).Elem().String(),
+				info: item.Index(3).Elem().String(),
+			}
+		}
+		selected := refToInt(v.Index(1))
+		row := refToInt(v.Index(2))
+		col := refToInt(v.Index(3))
+		grid := refToInt(v.Index(4))
+		singleton.popupMenu.Show(items, selected, row, col, grid)
+	}
+}
+
+func popupmenu_select(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		selected := refToInt(v.Index(0))
+		singleton.popupMenu.Select(selected)
+	}
+}
+
+// Parses content of the command line and messages. Content is an array of
+// chunks and every chunk is an array of attribute id and text.
+func parseTextChunks(val reflect.Value) []TextChunk {
+	content := make([]TextChunk, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		chunk := val.Index(i).Elem()
+		attrib := chunk.Index(0).Elem()
+		// Attribute may be a map if ext_linegrid is not enabled.
+		if attrib.Kind() != reflect.Map {
+			content[i].attribId = int(attrib.Convert(t_int).Int())
+		}
+		content[i].text = chunk.Index(1).Elem().String()
+	}
+	return content
+}
+
+func cmdline_show(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		content := parseTextChunks(v.Index(0).Elem())
+		pos := refToInt(v.Index(1))
+		firstc := v.Index(2).Elem().String()
+		prompt := v.Index(3).Elem().String()
+		indent := refToInt(v.Index(4))
+		level := refToInt(v.Index(5))
+		singleton.cmdline.Show(level, content, pos, firstc, prompt, indent)
+	}
+}
+
+func cmdline_pos(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		pos := refToInt(v.Index(0))
+		level := refToInt(v.Index(1))
+		singleton.cmdline.SetPos(pos, level)
+	}
+}
+
+func cmdline_special_char(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		char := v.Index(0).Elem().String()
+		shift := v.Index(1).Elem().Bool()
+		level := refToInt(v.Index(2))
+		singleton.cmdline.SetSpecialChar(char, shift, level)
+	}
+}
+
+func cmdline_hide(args []interface{}) {
+	for _, arg := range args {
+		// Older versions of neovim doesn't send the level.
+		level := 0
+		v := reflect.ValueOf(arg)
+		if v.Len() > 0 {
+			level = refToInt(v.Index(0))
+		}
+		singleton.cmdline.Hide(level)
+	}
+}
+
+func cmdline_block_show(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		linesv := v.Index(0).Elem()
+		lines := make([][]TextChunk, linesv.Len())
+		for i := 0; i < linesv.Len(); i++ {
+			lines[i] = parseTextChunks(linesv.Index(i).Elem())
+		}
+		singleton.cmdline.BlockShow(lines)
+	}
+}
+
+func cmdline_block_append(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		singleton.cmdline.BlockAppend(parseTextChunks(v.Index(0).Elem()))
+	}
+}
+
+func msg_show(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		kind := v.Index(0).Elem().String()
+		content := parseTextChunks(v.Index(1).Elem())
+		replaceLast := v.Index(2).Elem().Bool()
+		singleton.messages.Show(kind, content, replaceLast)
+	}
+}
+
+func msg_showmode(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		singleton.messages.SetShowMode(parseTextChunks(v.Index(0).Elem()))
+	}
+}
+
+func msg_showcmd(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		singleton.messages.SetShowCmd(parseTextChunks(v.Index(0).Elem()))
+	}
+}
+
+func msg_ruler(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		singleton.messages.SetRuler(parseTextChunks(v.Index(0).Elem()))
+	}
+}
+
+func msg_history_show(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		entriesv := v.Index(0).Elem()
+		entries := make([][]TextChunk, entriesv.Len())
+		for i := 0; i < entriesv.Len(); i++ {
+			// Every entry is an array of kind and content.
+			entry := entriesv.Index(i).Elem()
+			entries[i] = parseTextChunks(entry.Index(1).Elem())
+		}
+		singleton.messages.ShowHistory(entries)
+	}
+}
+
+// Parses tabs or buffers of the tabline. Every item is a map which contains
+// handle and name.
+func parseTablineItems(val reflect.Value, handleKey string, current int) []TablineItem {
+	items := make([]TablineItem, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		iter := val.Index(i).Elem().MapRange()
+		for iter.Next() {
+			switch iter.Key().String() {
+			case handleKey:
+				items[i].handle = refToInt(iter.Value())
+			case "name":
+				items[i].name = iter.Value().Elem().String()
+			}
 		}
-	*/
+		items[i].current = items[i].handle == current
+		items[i].isBuffer = handleKey == "buffer"
+	}
+	return items
+}
+
+func tabline_update(args []interface{}) {
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		curtab := refToInt(v.Index(0))
+		tabs := parseTablineItems(v.Index(1).Elem(), "tab", curtab)
+		buffers := []TablineItem{}
+		// Buffers are sent by newer versions of neovim.
+		if v.Len() >= 4 {
+			curbuf := refToInt(v.Index(2))
+			buffers = parseTablineItems(v.Index(3).Elem(), "buffer", curbuf)
+		}
+		singleton.tabline.Update(tabs, buffers)
+	}
 }
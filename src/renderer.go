@@ -1,27 +1,58 @@
 package main
 
 import (
-	"fmt"
+	"image"
+	"sort"
 	"unicode"
 )
 
 const (
-	UNSUPPORTED_GLYPH_ID = "Unsupported"
-	UNDERCURL_GLYPH_ID   = "Undercurl"
+	// Changed vertices which are closer than this are uploaded together,
+	// uploading a few unchanged vertices is cheaper than another call.
+	VERTEX_RANGE_MERGE_GAP = 32
+	// Dirty ranges are merged when they are more than this.
+	VERTEX_MAX_DIRTY_RANGES = 256
 )
 
-type FontAtlas struct {
-	texture    Texture
-	pos        IntVec2
-	characters map[string]IntRect
+// A range of the vertex data which is changed and needs to be uploaded,
+// end is exclusive.
+type VertexRange struct {
+	begin, end int
+}
+
+// Key of the face cache. Faces are looked up for every character and style.
+type faceCacheKey struct {
+	char               rune
+	wide, italic, bold bool
+}
+
+type faceCacheValue struct {
+	face      *FontFace
+	supported bool
 }
 
 type Renderer struct {
-	userFont    Font
+	// User fonts in the order of guifont option. First font is the main font
+	// and determines the cell size, the others are the fallback fonts.
+	userFonts []Font
+	// Fonts of the guifontwide option, used for double width cells before
+	// the user fonts.
+	wideFonts   []Font
 	defaultFont Font
+	// Supported faces of the characters, cleared when the fonts are changed.
+	faceCache map[faceCacheKey]faceCacheValue
+	// Resized copies of the faces for the zoomed grids.
+	scaledFaces map[scaledFaceKey]*FontFace
 	fontAtlas   FontAtlas
+	shaper      Shaper
 	// Vertex data holds vertices for cells. Every cell has 1 vertex.
 	vertexData []Vertex
+	// Changed parts of the vertex data since the last render, only these
+	// parts are uploaded to the gpu.
+	dirtyRanges []VertexRange
+	// Vertex data of the external windows begins here, and the main window
+	// renders the vertices before it.
+	externalBegin int
 	// Temporary values, can be used for checking whether the dimesions are same with requested.
 	_rows, _cols int
 	// Rows is row count and cols is column count of the vertex data.
@@ -45,10 +76,10 @@ func CreateRenderer() Renderer {
 	rglInit()
 
 	renderer := Renderer{
-		fontAtlas: FontAtlas{
-			texture:    CreateTexture(FONT_ATLAS_DEFAULT_SIZE, FONT_ATLAS_DEFAULT_SIZE),
-			characters: make(map[string]IntRect),
-		},
+		fontAtlas:   CreateFontAtlas(),
+		faceCache:   make(map[faceCacheKey]faceCacheValue),
+		scaledFaces: make(map[scaledFaceKey]*FontFace),
+		shaper:      CreateShaper(),
 	}
 
 	renderer.defaultFont = CreateDefaultFont()
@@ -59,12 +90,14 @@ func CreateRenderer() Renderer {
 	return renderer
 }
 
-func (renderer *Renderer) setFont(font Font) {
-	renderer.userFont = font
+// Sets the user fonts, fonts must have at least one font.
+func (renderer *Renderer) setFonts(fonts []Font) {
+	assert(len(fonts) > 0, "At least one font is required.")
+	renderer.userFonts = fonts
 	// resize default fonts
-	renderer.defaultFont.Resize(font.size)
+	renderer.defaultFont.Resize(fonts[0].size)
 	// update cell size if font size has changed
-	renderer.updateCellSize(&font)
+	renderer.updateCellSize(&renderer.userFonts[0])
 	// reset atlas
 	renderer.clearAtlas()
 }
@@ -75,18 +108,32 @@ func (renderer *Renderer) setFontSize(size float32) {
 		size = renderer.defaultFont.size
 	}
 	renderer.defaultFont.Resize(size)
-	if renderer.userFont.size > 0 {
-		renderer.userFont.Resize(size)
-		renderer.updateCellSize(&renderer.userFont)
+	for i := range renderer.userFonts {
+		renderer.userFonts[i].Resize(size)
+	}
+	for i := range renderer.wideFonts {
+		renderer.wideFonts[i].Resize(size)
+	}
+	if len(renderer.userFonts) > 0 {
+		renderer.updateCellSize(&renderer.userFonts[0])
 	} else {
 		renderer.updateCellSize(&renderer.defaultFont)
 	}
 	renderer.clearAtlas()
 }
 
+// Sets the fonts for double width cells, fonts may be empty.
+func (renderer *Renderer) setWideFonts(fonts []Font) {
+	for i := range fonts {
+		fonts[i].Resize(renderer.defaultFont.size)
+	}
+	renderer.wideFonts = fonts
+	renderer.clearAtlas()
+}
+
 func (renderer *Renderer) disableUserFont() {
-	if renderer.userFont.size > 0 {
-		renderer.userFont.size = 0
+	if len(renderer.userFonts) > 0 {
+		renderer.userFonts = nil
 		renderer.updateCellSize(&renderer.defaultFont)
 		renderer.clearAtlas()
 	}
@@ -108,7 +155,7 @@ func (renderer *Renderer) updateCellSize(font *Font) bool {
 	if w != singleton.cellWidth || h != singleton.cellHeight {
 		singleton.cellWidth = w
 		singleton.cellHeight = h
-		renderer._rows = singleton.window.height / h
+		renderer._rows = singleton.window.height/h - singleton.tabline.height()
 		renderer._cols = singleton.window.width / w
 		// We need to only resize if the mainloop is running because renderer is initialized
 		// before we attached to neovim as ui. We are updating _rows, _cols for this reason
@@ -124,9 +171,22 @@ func (renderer *Renderer) updateCellSize(font *Font) bool {
 func (renderer *Renderer) clearAtlas() {
 	defer measure_execution_time()()
 	// logDebug("Cleaning atlas.")
-	renderer.fontAtlas.texture.clear()
-	renderer.fontAtlas.characters = make(map[string]IntRect)
-	renderer.fontAtlas.pos = IntVec2{}
+	renderer.fontAtlas.clear()
+	// Atlas is cleared when the fonts are changed, and the supported faces
+	// and the shaping results depends on the fonts.
+	renderer.faceCache = make(map[faceCacheKey]faceCacheValue)
+	renderer.scaledFaces = make(map[scaledFaceKey]*FontFace)
+	renderer.shaper.clearCache()
+	singleton.contextMenu.updateChars()
+	singleton.fullDraw()
+}
+
+// Called when the glyphs in the atlas are moved or evicted, everything that
+// uses the atlas must be drawn again.
+func (renderer *Renderer) atlasChanged() {
+	if glyph, ok := renderer.fontAtlas.glyphs[GlyphKey{kind: GLYPH_UNDERCURL}]; ok {
+		rglSetUndercurlRect(renderer.fontAtlas.texture.glCoords(glyph.pos))
+	}
 	singleton.contextMenu.updateChars()
 	singleton.fullDraw()
 }
@@ -146,14 +206,30 @@ func (renderer *Renderer) createVertexData() {
 			renderer.vertexData[renderer.cellVertexPos(x, y)].pos = cellPos(x, y)
 		}
 	}
+	// Add scrollback rows of the smooth scrolling to data.
+	singleton.gridManager.createVertexData()
 	// Add cursor to data.
 	singleton.cursor.createVertexData()
+	// Add tabline to data.
+	singleton.tabline.createVertexData()
+	// Add messages to data.
+	singleton.messages.createVertexData()
+	// Add command line to data.
+	singleton.cmdline.createVertexData()
+	// Add neovim's popup menu to data.
+	singleton.popupMenu.createVertexData()
 	// Add popup menu to data.
 	singleton.contextMenu.createVertexData()
 	// DEBUG: draw font atlas to top right
 	if isDebugBuild() {
 		renderer.debugDrawFontAtlas()
 	}
+	// Add external windows to data.
+	renderer.externalBegin = len(renderer.vertexData)
+	for _, ext := range singleton.externalWindows {
+		ext.createVertexData()
+	}
+	renderer.markAllDirty()
 }
 
 func (renderer *Renderer) debugDrawFontAtlas() {
@@ -165,40 +241,96 @@ func (renderer *Renderer) debugDrawFontAtlas() {
 	}
 	storage := renderer.reserveVertexData(1)
 	storage.setCellPos(0, atlas_pos)
-	storage.setCellTex1(0, IntRect{0, 0, FONT_ATLAS_DEFAULT_SIZE, FONT_ATLAS_DEFAULT_SIZE})
+	texture := renderer.fontAtlas.texture
+	storage.setCellTex1(0, IntRect{0, 0, texture.width, texture.height})
 	storage.setCellFg(0, U8Color{R: 255, G: 255, B: 255, A: 255})
 }
 
 func (storage VertexDataStorage) setCellPos(index int, pos F32Rect) {
 	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellPos oob!")
 	storage.renderer.vertexData[storage.begin+index].pos = pos
+	storage.renderer.markDirty(storage.begin + index)
+}
+
+func (storage VertexDataStorage) setCellClip(index int, clip F32Vec2) {
+	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellClip oob!")
+	storage.renderer.vertexData[storage.begin+index].clip = clip
+	storage.renderer.markDirty(storage.begin + index)
 }
 
 func (storage VertexDataStorage) setCellTex1(index int, texPos IntRect) {
 	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellTex1 oob!")
 	tex1pos := storage.renderer.fontAtlas.texture.glCoords(texPos)
 	storage.renderer.vertexData[storage.begin+index].tex1 = tex1pos
+	storage.renderer.vertexData[storage.begin+index].colored.X = storage.renderer.fontAtlas.coloredValue(texPos)
+	storage.renderer.markDirty(storage.begin + index)
 }
 
 func (storage VertexDataStorage) setCellTex2(index int, texPos IntRect) {
 	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellTex2 oob!")
 	tex2pos := storage.renderer.fontAtlas.texture.glCoords(texPos)
 	storage.renderer.vertexData[storage.begin+index].tex2 = tex2pos
+	storage.renderer.vertexData[storage.begin+index].colored.Y = storage.renderer.fontAtlas.coloredValue(texPos)
+	storage.renderer.markDirty(storage.begin + index)
 }
 
 func (storage VertexDataStorage) setCellFg(index int, fg U8Color) {
 	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellFg oob!")
 	storage.renderer.vertexData[storage.begin+index].fg = fg.toF32()
+	storage.renderer.markDirty(storage.begin + index)
 }
 
 func (storage VertexDataStorage) setCellBg(index int, bg U8Color) {
 	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellBg oob!")
 	storage.renderer.vertexData[storage.begin+index].bg = bg.toF32()
+	storage.renderer.markDirty(storage.begin + index)
 }
 
 func (storage VertexDataStorage) setCellSp(index int, sp U8Color) {
 	assert_debug(index >= 0 && storage.begin+index < storage.end, "vds.setCellSp oob!")
 	storage.renderer.vertexData[storage.begin+index].sp = sp.toF32()
+	storage.renderer.markDirty(storage.begin + index)
+}
+
+// Sets the character and colors of the cell at index using the attribute. This
+// is used by the ui elements which are not a part of the grids, like the popup
+// menu. Does not set the position of the cell. Wide characters are drawn to
+// the next cell too, and the cells must be set from left to right. Grids pass
+// their own wide cells, others decide it from the character.
+func (storage VertexDataStorage) setCellWithAttrib(index int, char rune, wide bool, attrib HighlightAttribute) {
+	storage.setScaledCellWithAttrib(index, char, wide, attrib, 1)
+}
+
+// Same with setCellWithAttrib, used by the zoomed grids which have the scale.
+func (storage VertexDataStorage) setScaledCellWithAttrib(index int, char rune, wide bool, attrib HighlightAttribute, scale float32) {
+	fg, bg, sp := singleton.gridManager.attribColors(attrib)
+	var atlasPos IntRect
+	var secAtlasPos IntRect
+	if char != 0 && char != WIDE_CHAR_CONTINUATION {
+		atlasPos = storage.renderer.getScaledCharPos(char, wide,
+			attrib.italic, attrib.bold, attrib.underline, attrib.strikethrough, scale)
+		if cellWidth, _ := scaledCellSize(scale); atlasPos.W > cellWidth {
+			atlasPos.W /= 2
+			secAtlasPos = atlasPos
+			secAtlasPos.X += cellWidth
+		}
+	}
+	if storage.begin+index+1 < storage.end {
+		storage.setCellTex2(index+1, secAtlasPos)
+		// Color of the next cell is only changed if this cell draws on it.
+		if secAtlasPos.W > 0 {
+			storage.setCellFg(index+1, fg)
+		}
+	}
+	if attrib.undercurl {
+		storage.renderer.checkUndercurlPos()
+		storage.setCellSp(index, sp)
+	} else {
+		storage.setCellSp(index, U8Color{})
+	}
+	storage.setCellTex1(index, atlasPos)
+	storage.setCellFg(index, fg)
+	storage.setCellBg(index, bg)
 }
 
 // Reserve calculates needed vertex size for given cell count,
@@ -210,6 +342,7 @@ func (renderer *Renderer) reserveVertexData(cellCount int) VertexDataStorage {
 	for i := 0; i < cellCount; i++ {
 		renderer.vertexData = append(renderer.vertexData, Vertex{})
 	}
+	renderer.dirtyRanges = append(renderer.dirtyRanges, VertexRange{begin: begin, end: len(renderer.vertexData)})
 	return VertexDataStorage{
 		renderer: renderer,
 		begin:    begin,
@@ -228,32 +361,106 @@ func (renderer *Renderer) copyRowData(dst, src, left, right int) {
 		src_data := renderer.vertexData[src_begin+i]
 		dst_data.tex2 = src_data.tex2
 		dst_data.tex1 = src_data.tex1
+		dst_data.colored = src_data.colored
 		dst_data.fg = src_data.fg
 		dst_data.bg = src_data.bg
 		dst_data.sp = src_data.sp
 	}
+	renderer.dirtyRanges = append(renderer.dirtyRanges, VertexRange{begin: dst_begin, end: dst_begin + src_end - src_begin})
+}
+
+// Sets the position of the cell in pixels and the vertical range which it is
+// visible in. Cells are placed with cellPos and not clipped by default.
+func (renderer *Renderer) setCellPos(x, y int, pos F32Rect, clip F32Vec2) {
+	index := renderer.cellVertexPos(x, y)
+	renderer.vertexData[index].pos = pos
+	renderer.vertexData[index].clip = clip
+	renderer.markDirty(index)
 }
 
 func (renderer *Renderer) setCellTex1(x, y int, pos IntRect) {
-	tex1pos := renderer.fontAtlas.texture.glCoords(pos)
-	renderer.vertexData[renderer.cellVertexPos(x, y)].tex1 = tex1pos
+	index := renderer.cellVertexPos(x, y)
+	renderer.vertexData[index].tex1 = renderer.fontAtlas.texture.glCoords(pos)
+	renderer.vertexData[index].colored.X = renderer.fontAtlas.coloredValue(pos)
+	renderer.markDirty(index)
 }
 
 func (renderer *Renderer) setCellTex2(x, y int, pos IntRect) {
-	tex2pos := renderer.fontAtlas.texture.glCoords(pos)
-	renderer.vertexData[renderer.cellVertexPos(x, y)].tex2 = tex2pos
+	index := renderer.cellVertexPos(x, y)
+	renderer.vertexData[index].tex2 = renderer.fontAtlas.texture.glCoords(pos)
+	renderer.vertexData[index].colored.Y = renderer.fontAtlas.coloredValue(pos)
+	renderer.markDirty(index)
 }
 
 func (renderer *Renderer) setCellFg(x, y int, fg U8Color) {
-	renderer.vertexData[renderer.cellVertexPos(x, y)].fg = fg.toF32()
+	index := renderer.cellVertexPos(x, y)
+	renderer.vertexData[index].fg = fg.toF32()
+	renderer.markDirty(index)
 }
 
 func (renderer *Renderer) setCellBg(x, y int, bg U8Color) {
-	renderer.vertexData[renderer.cellVertexPos(x, y)].bg = bg.toF32()
+	index := renderer.cellVertexPos(x, y)
+	renderer.vertexData[index].bg = bg.toF32()
+	renderer.markDirty(index)
 }
 
 func (renderer *Renderer) setCellSp(x, y int, sp U8Color) {
-	renderer.vertexData[renderer.cellVertexPos(x, y)].sp = sp.toF32()
+	index := renderer.cellVertexPos(x, y)
+	renderer.vertexData[index].sp = sp.toF32()
+	renderer.markDirty(index)
+}
+
+// Marks the vertex at the index as changed. Cells are mostly set from left to
+// right, and the last range is extended for them.
+func (renderer *Renderer) markDirty(index int) {
+	if last := len(renderer.dirtyRanges) - 1; last >= 0 {
+		r := &renderer.dirtyRanges[last]
+		if index >= r.begin && index < r.end {
+			return
+		} else if index == r.end {
+			r.end++
+			return
+		}
+	}
+	if len(renderer.dirtyRanges) >= VERTEX_MAX_DIRTY_RANGES {
+		renderer.dirtyRanges = coalesceVertexRanges(renderer.dirtyRanges)
+		if len(renderer.dirtyRanges) >= VERTEX_MAX_DIRTY_RANGES {
+			// Too many small changes, upload everything between them.
+			begin := renderer.dirtyRanges[0].begin
+			end := renderer.dirtyRanges[len(renderer.dirtyRanges)-1].end
+			renderer.dirtyRanges = append(renderer.dirtyRanges[:0], VertexRange{begin: begin, end: end})
+		}
+	}
+	renderer.dirtyRanges = append(renderer.dirtyRanges, VertexRange{begin: index, end: index + 1})
+}
+
+// Marks all vertex data as changed.
+func (renderer *Renderer) markAllDirty() {
+	renderer.dirtyRanges = append(renderer.dirtyRanges[:0], VertexRange{begin: 0, end: len(renderer.vertexData)})
+}
+
+// Sorts the ranges and merges the overlapping ones, and the ones which are
+// closer than VERTEX_RANGE_MERGE_GAP to each other. The result uses the same
+// memory with the ranges.
+func coalesceVertexRanges(ranges []VertexRange) []VertexRange {
+	if len(ranges) <= 1 {
+		return ranges
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].begin < ranges[j].begin
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.begin <= last.end+VERTEX_RANGE_MERGE_GAP {
+			if r.end > last.end {
+				last.end = r.end
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
 }
 
 func (renderer *Renderer) debugGetCellData(x, y int) Vertex {
@@ -276,6 +483,8 @@ func (renderer *Renderer) debugGetCellData(x, y int) Vertex {
 //     v Row, y, second
 // This function returns position rectangle of the cell needed for opengl.
 func cellPos(x, y int) F32Rect {
+	// Rows are begin after the tabline.
+	x += singleton.tabline.height()
 	return F32Rect{
 		X: float32(y * singleton.cellWidth),
 		Y: float32(x * singleton.cellHeight),
@@ -288,40 +497,27 @@ func (renderer *Renderer) cellVertexPos(x, y int) int {
 	return x*renderer.cols + y
 }
 
-func (renderer *Renderer) nextAtlasPosition(width int) IntVec2 {
-	atlas := &renderer.fontAtlas
-	pos := atlas.pos
-	if pos.X+width >= FONT_ATLAS_DEFAULT_SIZE {
-		pos.X = 0
-		pos.Y += singleton.cellHeight
+// Returns the first face that contains the character, and false if no face
+// contains it. Results are cached for every character and style.
+func (renderer *Renderer) getSupportedFace(char rune, wide, italic, bold bool) (*FontFace, bool) {
+	key := faceCacheKey{char: char, wide: wide, italic: italic, bold: bold}
+	if value, ok := renderer.faceCache[key]; ok {
+		return value.face, value.supported
 	}
-	if pos.Y+singleton.cellHeight >= FONT_ATLAS_DEFAULT_SIZE {
-		// Fully filled
-		logMessage(LEVEL_ERROR, TYPE_RENDERER, "Font atlas is full.")
-		renderer.clearAtlas()
-		// The clear atlas function calls a popup menu function to create its
-		// chars. This means the nextAtlasPosition is recursively calling
-		// itself when the font atlas is full. And the atlas pos is changed.
-		// We need to reset pos to atlas pos for resuming from where it is.
-		// Even if the popup menu function is not called, the clear atlas
-		// function will clear the atlas pos and ve resume from beginning.
-		// NOTE: When the current characters on the screen can't fit the texture
-		// the characters being wrongly positioned. It doesn't crash but sometimes
-		// the position is going out of bounds from texture. And assertion will fail.
-		// This only happens in debug mode to me because texture size is small in debug.
-		pos = atlas.pos
-	}
-	atlas.pos.X = pos.X + width
-	atlas.pos.Y = pos.Y
-	assert(pos.X+width < FONT_ATLAS_DEFAULT_SIZE, "atlas: width out of bounds, pos:", pos, "width:", width)
-	assert(pos.Y+singleton.cellHeight < FONT_ATLAS_DEFAULT_SIZE, "atlas: height out of bounds, pos:", pos)
-	return pos
-}
-
-func (renderer *Renderer) getSupportedFace(char rune, italic, bold bool) (*FontFace, bool) {
-	// First try the user font for this character.
-	if renderer.userFont.size > 0 {
-		face := renderer.userFont.GetSuitableFace(italic, bold)
+	face, supported := renderer.findSupportedFace(char, wide, italic, bold)
+	renderer.faceCache[key] = faceCacheValue{face: face, supported: supported}
+	return face, supported
+}
+
+func (renderer *Renderer) findSupportedFace(char rune, wide, italic, bold bool) (*FontFace, bool) {
+	fonts := renderer.userFonts
+	if wide && len(renderer.wideFonts) > 0 {
+		// Wide fonts have priority for double width cells.
+		fonts = append(append([]Font{}, renderer.wideFonts...), fonts...)
+	}
+	// First try the user fonts for this character, in order.
+	for i := range fonts {
+		face := fonts[i].GetSuitableFace(italic, bold)
 		if face != nil && face.ContainsGlyph(char) {
 			return face, true
 		}
@@ -333,8 +529,15 @@ func (renderer *Renderer) getSupportedFace(char rune, italic, bold bool) (*FontF
 		if face.ContainsGlyph(char) {
 			return face, true
 		}
+		// Regular faces of the user fonts are better than the default font.
+		for i := range fonts {
+			face := fonts[i].regular
+			if face.ContainsGlyph(char) {
+				return face, true
+			}
+		}
 	}
-	// Use default font if user font not supports this glyph.
+	// Use default font if user fonts not supports this glyph.
 	// Default regular font has (needs to) more glyphs.
 	face := renderer.defaultFont.GetSuitableFace(false, false)
 	assert(face != nil, "Default font's regular face cannot be a nil pointer.")
@@ -342,84 +545,99 @@ func (renderer *Renderer) getSupportedFace(char rune, italic, bold bool) (*FontF
 }
 
 func (renderer *Renderer) checkUndercurlPos() {
-	if _, ok := renderer.fontAtlas.characters[UNDERCURL_GLYPH_ID]; ok == false {
+	key := GlyphKey{kind: GLYPH_UNDERCURL}
+	if _, ok := renderer.fontAtlas.glyphs[key]; ok == false {
 		// Render undercurl image
 		textImage := renderer.defaultFont.regular.renderUndercurl()
-		textPos := renderer.nextAtlasPosition(singleton.cellWidth)
-		rect := IntRect{
-			X: textPos.X,
-			Y: textPos.Y,
-			W: singleton.cellWidth,
-			H: singleton.cellHeight,
-		}
-		// Draw image to empty position of atlas texture
-		renderer.fontAtlas.texture.updatePart(textImage, rect)
-		// Add undercurl to atlas characters
-		renderer.fontAtlas.characters[UNDERCURL_GLYPH_ID] = rect
+		// Undercurl is used by the shader for every cell and never evicted.
+		rect := renderer.fontAtlas.add(key, textImage, false, true)
 		// Set undercurl texture position uniform
 		rglSetUndercurlRect(renderer.fontAtlas.texture.glCoords(rect))
 	}
 }
 
 // Returns given character position at the font atlas.
-func (renderer *Renderer) getCharPos(char rune, italic, bold, underline, strikethrough bool) IntRect {
-	assert_debug(char != ' ' && char != 0, "char is zero or space")
+// If wide is true, the character will be rendered for two cells and the width
+// of the returned rectangle will be cellWidth*2.
+func (renderer *Renderer) getCharPos(char rune, wide, italic, bold, underline, strikethrough bool) IntRect {
+	return renderer.getScaledCharPos(char, wide, italic, bold, underline, strikethrough, 1)
+}
+
+// Same with getCharPos, but the character is rendered for the cells of the
+// zoomed grids which have the scale.
+func (renderer *Renderer) getScaledCharPos(char rune, wide, italic, bold, underline, strikethrough bool, scale float32) IntRect {
+	assert_debug(char != ' ' && char != 0 && char != WIDE_CHAR_CONTINUATION, "char is zero, space or continuation")
+	// guifont may underline or strikethrough all text
+	underline = underline || singleton.uiOptions.parsed.guifont.underline
+	strikethrough = strikethrough || singleton.uiOptions.parsed.guifont.strikethrough
 	// disable underline or strikethrough if this glyph is not alphanumeric
-	if !unicode.IsLetter(char) {
+	if !unicode.IsLetter(graphemeBase(char)) {
 		underline = false
 		strikethrough = false
 	}
-	// generate specific id for this character
-	id := fmt.Sprintf("%d%t%t%t%t", char, italic, bold, underline, strikethrough)
-	if pos, ok := renderer.fontAtlas.characters[id]; ok == true {
+	// Get suitable font and check for glyph
+	fontFace, ok := renderer.getSupportedFace(graphemeBase(char), wide, italic, bold)
+	if scale != 1 {
+		fontFace = renderer.scaledFace(fontFace, scale)
+	}
+	key := GlyphKey{
+		kind:          GLYPH_CHAR,
+		face:          fontFace,
+		size:          fontFace.size,
+		char:          char,
+		wide:          wide,
+		italic:        italic,
+		bold:          bold,
+		underline:     underline,
+		strikethrough: strikethrough,
+	}
+	if !ok {
+		// If this character can't be drawed, an empty rectangle will be drawed.
+		// And we are reducing this rectangle count in the font atlas to 1.
+		// Every unsupported glyph will use it.
+		key = GlyphKey{kind: GLYPH_UNSUPPORTED, size: float64(scale), wide: wide}
+	}
+	if pos, ok := renderer.fontAtlas.get(key); ok {
 		// use stored texture
 		return pos
-	} else {
-		// Get suitable font and check for glyph
-		fontFace, ok := renderer.getSupportedFace(char, italic, bold)
-		if !ok {
-			// If this character can't be drawed, an empty rectangle will be drawed.
-			// And we are reducing this rectangle count in the font atlas to 1.
-			// Every unsupported glyph will use it.
-			id = UNSUPPORTED_GLYPH_ID
-			pos, ok := renderer.fontAtlas.characters[id]
-			if ok {
-				return pos
-			}
-		}
-		// Render character to an image
-		textImage := fontFace.RenderChar(char, underline, strikethrough)
-		if textImage == nil {
-			logMessage(LEVEL_ERROR, TYPE_RENDERER, "Failed to render glyph:", string(char), char)
-			id = UNSUPPORTED_GLYPH_ID
-			pos, ok := renderer.fontAtlas.characters[id]
-			if ok {
-				return pos
-			}
-		}
-		width := textImage.Rect.Dx()
-		// Get empty atlas position for this character
-		text_pos := renderer.nextAtlasPosition(width)
-		position := IntRect{
-			X: text_pos.X,
-			Y: text_pos.Y,
-			W: width,
-			H: singleton.cellHeight,
+	}
+	// Render character to an image
+	var textImage *image.RGBA
+	var colored bool
+	width, height := scaledCellSize(scale)
+	withCellSize(width, height, func() {
+		textImage, colored = fontFace.RenderChar(char, wide, underline, strikethrough)
+	})
+	if textImage == nil {
+		logMessage(LEVEL_ERROR, TYPE_RENDERER, "Failed to render glyph:", graphemeString(char), char)
+		key = GlyphKey{kind: GLYPH_UNSUPPORTED, size: float64(scale), wide: wide}
+		if pos, ok := renderer.fontAtlas.get(key); ok {
+			return pos
 		}
-		// Draw text to empty position of atlas texture
-		renderer.fontAtlas.texture.updatePart(textImage, position)
-		// Add this font to character list for further use
-		renderer.fontAtlas.characters[id] = position
-		return position
 	}
+	// Draw text to empty position of atlas texture and add it to
+	// character list for further use
+	return renderer.fontAtlas.add(key, textImage, colored, false)
+}
+
+// Returns the atlas position of a shaped cell. Every different combination of
+// the glyphs in a cell is rendered once.
+func (renderer *Renderer) getShapedPos(face *FontFace, glyphs []CellGlyph, underline, strikethrough bool) IntRect {
+	key := shapedGlyphKey(face, glyphs, underline, strikethrough)
+	if pos, ok := renderer.fontAtlas.get(key); ok {
+		return pos
+	}
+	textImage := face.RenderShaped(glyphs, underline, strikethrough)
+	return renderer.fontAtlas.add(key, textImage, false, false)
 }
 
+// If wide is true, the character is drawn on this and the next cell.
 func (renderer *Renderer) DrawCellCustom(
-	x, y int, char rune, fg, bg, sp U8Color,
+	x, y int, char rune, wide bool, fg, bg, sp U8Color,
 	italic, bold, underline, undercurl, strikethrough bool) {
 	// draw Background
 	renderer.setCellBg(x, y, bg)
-	if char == 0 {
+	if char == 0 || char == WIDE_CHAR_CONTINUATION {
 		// This is an empty cell, clear foreground data
 		if y+1 < renderer.cols {
 			// Clear next cells second texture
@@ -442,9 +660,9 @@ func (renderer *Renderer) DrawCellCustom(
 	}
 
 	// get character position in atlas texture
-	atlasPos := renderer.getCharPos(char, italic, bold, underline, strikethrough)
+	atlasPos := renderer.getCharPos(char, wide, italic, bold, underline, strikethrough)
 	if atlasPos.W > singleton.cellWidth {
-		// The atlas width will be 2 times more if the char is a multiwidth char
+		// The atlas width will be 2 times more if the char is a wide char
 		// and we are dividing atlas to 2. One for current cell and one for next.
 		atlasPos.W /= 2
 		if y+1 < renderer.cols {
@@ -460,7 +678,7 @@ func (renderer *Renderer) DrawCellCustom(
 			renderer.setCellTex2(x, y+1, secAtlasPos)
 			renderer.setCellFg(x, y+1, fg)
 		}
-	} else {
+	} else if y+1 < renderer.cols {
 		// Clear second texture.
 		renderer.setCellTex2(x, y+1, IntRect{})
 	}
@@ -469,39 +687,21 @@ func (renderer *Renderer) DrawCellCustom(
 	renderer.setCellFg(x, y, fg)
 }
 
-func (renderer *Renderer) DrawCellWithAttrib(x, y int, cell Cell, attrib HighlightAttribute) {
-	fg := singleton.gridManager.defaultFg
-	bg := singleton.gridManager.defaultBg
-	sp := singleton.gridManager.defaultSp
-	// bg transparency, this only affects default attribute backgrounds
-	bg.A = uint8(singleton.options.transparency * 255)
-	// set attribute colors
-	if attrib.foreground.A > 0 {
-		fg = attrib.foreground
-	}
-	if attrib.background.A > 0 {
-		bg = attrib.background
-	}
-	if attrib.special.A > 0 {
-		sp = attrib.special
-	}
-	// reverse foreground and background
-	if attrib.reverse {
-		fg, bg = bg, fg
-	}
+func (renderer *Renderer) DrawCellWithAttrib(x, y int, cell Cell, wide bool, attrib HighlightAttribute) {
+	fg, bg, sp := singleton.gridManager.attribColors(attrib)
 	// draw cell
-	renderer.DrawCellCustom(x, y, cell.char, fg, bg, sp,
+	renderer.DrawCellCustom(x, y, cell.char, wide, fg, bg, sp,
 		attrib.italic, attrib.bold, attrib.underline, attrib.undercurl, attrib.strikethrough)
 }
 
-func (renderer *Renderer) DrawCell(x, y int, cell Cell) {
+func (renderer *Renderer) DrawCell(x, y int, cell Cell, wide bool) {
 	if cell.attribId > 0 {
-		renderer.DrawCellWithAttrib(x, y, cell, singleton.gridManager.attributes[cell.attribId])
+		renderer.DrawCellWithAttrib(x, y, cell, wide, singleton.gridManager.attributes[cell.attribId])
 	} else {
 		// attrib id 0 is default palette
 		bg := singleton.gridManager.defaultBg
 		bg.A = uint8(singleton.options.transparency * 255)
-		renderer.DrawCellCustom(x, y, cell.char,
+		renderer.DrawCellCustom(x, y, cell.char, wide,
 			singleton.gridManager.defaultFg, bg, singleton.gridManager.defaultSp,
 			false, false, false, false, false)
 	}
@@ -509,9 +709,16 @@ func (renderer *Renderer) DrawCell(x, y int, cell Cell) {
 
 func (renderer *Renderer) update() {
 	if renderer.drawCall || renderer.fullDrawCall {
-		renderer.drawCells(renderer.fullDrawCall)
+		fullDraw := renderer.fullDrawCall
 		renderer.fullDrawCall = false
 		renderer.drawCall = false
+		renderer.drawCells(fullDraw)
+		// Glyphs may be moved or evicted from the atlas while drawing, and
+		// the cells drawn before that are pointing to the wrong glyphs.
+		if renderer.fullDrawCall {
+			renderer.fullDrawCall = false
+			renderer.drawCells(true)
+		}
 	}
 	if renderer.renderCall {
 		renderer.render()
@@ -523,8 +730,25 @@ func (renderer *Renderer) update() {
 // Dont use directly. Use singleton.draw() or force full draw with singleton.fullDraw()
 func (renderer *Renderer) drawCells(fullDraw bool) {
 	defer measure_execution_time()()
-	// Draw in order
-	for _, grid := range singleton.gridManager.sortGrids() {
+	renderer.fontAtlas.frame++
+	gridManager := &singleton.gridManager
+	grids := gridManager.sortGrids()
+	if fullDraw {
+		// Positions of the grids are only changed with full draws.
+		gridManager.updateCellOwners()
+	}
+	// Draw in order, only the top grid of a screen cell draws it.
+	for _, grid := range grids {
+		if grid.typ == GridTypeExternal {
+			if ext, ok := singleton.externalWindows[grid.id]; ok && !grid.hidden {
+				ext.draw(grid, fullDraw)
+			}
+			continue
+		}
+		if grid.zoomed() {
+			grid.drawZoomed(fullDraw)
+			continue
+		}
 		if !grid.hidden {
 			// Sometimes neovim grids can be bigger than the window area.
 			// This calculation is only needed by multigrid.
@@ -536,12 +760,21 @@ func (renderer *Renderer) drawCells(fullDraw bool) {
 			if grid.sCol+cols > renderer.cols {
 				cols = renderer.cols - grid.sCol
 			}
-			// NOTE: We need to also check overlaped cells and only draw frontest cell in the same call.
 			for x := 0; x < rows; x++ {
+				if singleton.options.ligaturesEnabled {
+					if fullDraw || grid.rowNeedsDraw(x, cols) {
+						renderer.drawShapedRow(grid, x, cols)
+					}
+					continue
+				}
 				for y := 0; y < cols; y++ {
 					cell := grid.getCell(x, y)
 					if fullDraw || cell.needsDraw {
-						renderer.DrawCell(grid.sRow+x, grid.sCol+y, cell)
+						if gridManager.ownsCell(grid, grid.sRow+x, grid.sCol+y) {
+							renderer.drawGridCell(grid, x, y, cell, grid.isWide(x, y))
+						} else {
+							gridManager.redrawBlendedCell(grid.sRow+x, grid.sCol+y)
+						}
 						grid.cells[x][y].needsDraw = false
 					}
 				}
@@ -550,15 +783,25 @@ func (renderer *Renderer) drawCells(fullDraw bool) {
 	}
 	// Draw cursor one more time.
 	singleton.cursor.Draw()
+	// Tabline, messages, command line and popup menu uses grid attributes
+	// and font atlas like the cells.
+	if fullDraw {
+		singleton.tabline.Draw()
+		singleton.messages.Draw()
+		singleton.cmdline.Draw()
+		singleton.popupMenu.Draw()
+	}
 	// Render changes
 	singleton.render()
 }
 
 // Don't call this function directly. Use singleton.render()
 func (renderer *Renderer) render() {
-	rglUpdateVertices(renderer.vertexData)
+	rglUpdateVertices(renderer.vertexData, coalesceVertexRanges(renderer.dirtyRanges))
+	renderer.dirtyRanges = renderer.dirtyRanges[:0]
 	rglClearScreen(singleton.gridManager.defaultBg)
-	rglRender()
+	rglRender(0, renderer.externalBegin)
+	renderExternalWindows()
 }
 
 func (renderer *Renderer) Close() {
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Draws every cell of a 200x50 screen when all glyphs are already in the
+// atlas, which is the common case for redraws.
+func BenchmarkFullRedraw(b *testing.B) {
+	const rows, cols = 50, 200
+	const text = "func (renderer *Renderer) drawCells(fullDraw bool) { // Draw in order }"
+	singleton.window.dpi = 96
+	renderer := &singleton.renderer
+	*renderer = Renderer{
+		defaultFont: CreateDefaultFont(),
+		faceCache:   make(map[faceCacheKey]faceCacheValue),
+		fontAtlas: FontAtlas{
+			texture: Texture{width: FONT_ATLAS_DEFAULT_SIZE, height: FONT_ATLAS_DEFAULT_SIZE},
+			glyphs:  make(map[GlyphKey]*AtlasGlyph),
+			colored: make(map[IntVec2]bool),
+		},
+		rows:       rows,
+		cols:       cols,
+		vertexData: make([]Vertex, rows*cols),
+	}
+	singleton.cellWidth, singleton.cellHeight = renderer.defaultFont.GetCellSize()
+	cells := make([]Cell, rows*cols)
+	for i := range cells {
+		// Spaces are empty cells like in the grids.
+		if text[i%len(text)] == ' ' {
+			continue
+		}
+		cells[i].char = rune(text[i%len(text)])
+		// Glyphs are added without rendering, because there is no opengl
+		// context for the texture.
+		face, _ := renderer.getSupportedFace(cells[i].char, false, false, false)
+		key := GlyphKey{kind: GLYPH_CHAR, face: face, size: face.size, char: cells[i].char}
+		renderer.fontAtlas.glyphs[key] = &AtlasGlyph{
+			pos: IntRect{W: singleton.cellWidth, H: singleton.cellHeight},
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for x := 0; x < rows; x++ {
+			for y := 0; y < cols; y++ {
+				renderer.DrawCell(x, y, cells[x*cols+y], false)
+			}
+		}
+	}
+}
+
+func Test_coalesceVertexRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []VertexRange
+		want   []VertexRange
+	}{
+		{
+			name:   "Overlapping",
+			ranges: []VertexRange{{100, 120}, {0, 5}, {115, 130}},
+			want:   []VertexRange{{0, 5}, {100, 130}},
+		},
+		{
+			name:   "Close",
+			ranges: []VertexRange{{0, 1}, {1 + VERTEX_RANGE_MERGE_GAP, 100}},
+			want:   []VertexRange{{0, 100}},
+		},
+		{
+			name:   "Far",
+			ranges: []VertexRange{{200, 201}, {0, 1}},
+			want:   []VertexRange{{0, 1}, {200, 201}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coalesceVertexRanges(tt.ranges)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coalesceVertexRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_blendColor(t *testing.T) {
+	under := U8Color{R: 200, G: 100, B: 0, A: 255}
+	over := U8Color{R: 0, G: 100, B: 200, A: 255}
+	if got := blendColor(0, under, over); got != over {
+		t.Errorf("blendColor(0) = %v, want %v", got, over)
+	}
+	if got := blendColor(100, under, over); got != under {
+		t.Errorf("blendColor(100) = %v, want %v", got, under)
+	}
+	if got, want := blendColor(30, under, over), (U8Color{R: 60, G: 100, B: 140, A: 255}); got != want {
+		t.Errorf("blendColor(30) = %v, want %v", got, want)
+	}
+}
+
+func Test_cellBelow(t *testing.T) {
+	singleton.gridManager = CreateGridManager()
+	gridManager := &singleton.gridManager
+	gridManager.attributes[1] = HighlightAttribute{bold: true}
+	gridManager.grids[1] = &Grid{id: 1, rows: 10, cols: 20}
+	gridManager.grids[2] = &Grid{id: 2, number: 1, typ: GridTypeFloat, sRow: 2, sCol: 3, rows: 2, cols: 2}
+	gridManager.grids[3] = &Grid{id: 3, number: 2, typ: GridTypeFloat, sRow: 1, sCol: 1, rows: 4, cols: 6}
+	for _, grid := range gridManager.grids {
+		grid.cells = make([][]Cell, grid.rows)
+		for x := range grid.cells {
+			grid.cells[x] = make([]Cell, grid.cols)
+			for y := range grid.cells[x] {
+				grid.cells[x][y] = Cell{char: rune('0' + grid.id)}
+			}
+		}
+	}
+	gridManager.grids[2].cells[1][1].attribId = 1
+	gridManager.sortGrids()
+	top := gridManager.grids[3]
+	if cell, attrib, _ := gridManager.cellBelow(top, 3, 4); cell.char != '2' || !attrib.bold {
+		t.Errorf("cellBelow() = %v, %v, want the bold cell of grid 2", cell, attrib)
+	}
+	if cell, _, _ := gridManager.cellBelow(top, 1, 1); cell.char != '1' {
+		t.Errorf("cellBelow() = %v, want the cell of the default grid", cell)
+	}
+	gridManager.grids[2].hidden = true
+	if cell, _, _ := gridManager.cellBelow(top, 3, 4); cell.char != '1' {
+		t.Errorf("cellBelow() = %v, want the cell of the default grid under the hidden float", cell)
+	}
+}
+
+func Test_atlasAllocate(t *testing.T) {
+	atlas := FontAtlas{texture: Texture{width: 100, height: 100}}
+	// Glyphs of the zoomed grids are taller and placed to their own shelves.
+	sizes := []struct{ width, height int }{{10, 20}, {20, 40}, {10, 20}, {20, 40}}
+	want := []IntRect{
+		{X: 0, Y: 0, W: 10, H: 20},
+		{X: 0, Y: 20, W: 20, H: 40},
+		{X: 10, Y: 0, W: 10, H: 20},
+		{X: 20, Y: 20, W: 20, H: 40},
+	}
+	for i, size := range sizes {
+		pos, ok := atlas.allocate(size.width, size.height)
+		if !ok || pos != want[i] {
+			t.Errorf("allocate(%v, %v) = %v, %v, want %v", size.width, size.height, pos, ok, want[i])
+		}
+	}
+	if _, ok := atlas.allocate(10, 60); ok {
+		t.Errorf("allocate() must fail when there is no space for a new shelf")
+	}
+	// Empty shelves are removed and their space is used by the new shelves.
+	atlas.free(want[1])
+	atlas.free(want[3])
+	if pos, ok := atlas.allocate(10, 60); !ok || pos != (IntRect{X: 0, Y: 20, W: 10, H: 60}) {
+		t.Errorf("allocate() = %v, %v, want the space of the removed shelf", pos, ok)
+	}
+	atlas.free(want[0])
+	atlas.free(want[2])
+	if pos, ok := atlas.allocate(10, 20); !ok || pos != want[0] {
+		t.Errorf("allocate() = %v, %v, want the gap above the shelves %v", pos, ok, want[0])
+	}
+}
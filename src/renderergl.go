@@ -24,6 +24,12 @@ type Vertex struct {
 	bg F32Color // layout 4
 	// special color
 	sp F32Color // layout 5
+	// 1 if the texture is a color glyph which must not be tinted with the
+	// foreground color. X is for the first and Y is for the second texture.
+	colored F32Vec2 // layout 6
+	// Vertical pixel range which the cell is visible in, used by the smooth
+	// scrolling. Zero means the cell is not clipped.
+	clip F32Vec2 // layout 7
 }
 
 const sizeof_Vertex = int32(unsafe.Sizeof(Vertex{}))
@@ -35,6 +41,7 @@ var RGL struct {
 	fbo               uint32 // Framebuffer Object (Only used for clearing textures)
 	shader_program    uint32
 	vertex_buffer_len int // Length of the vertex data is equals to rendered quads
+	max_texture_size  int // Maximum width and height of the textures
 }
 
 //go:embed shader.glsl
@@ -54,15 +61,39 @@ func rglInit() {
 	gl.UseProgram(RGL.shader_program)
 	rglCheckError("use program")
 
+	// Initialize vbo
+	gl.GenBuffers(1, &RGL.vbo)
+	rglCheckError("gen vbo")
+
 	// Initialize vao
-	gl.GenVertexArrays(1, &RGL.vao)
-	gl.BindVertexArray(RGL.vao)
+	RGL.vao = rglCreateVertexArray()
+
+	// Create framebuffer object
+	// We dont need to bind framebuffer because we need it only when clearing texture
+	gl.GenFramebuffers(1, &RGL.fbo)
+	rglCheckError("gen framebuffer")
+
+	var maxTextureSize int32
+	gl.GetIntegerv(gl.MAX_TEXTURE_SIZE, &maxTextureSize)
+	RGL.max_texture_size = int(maxTextureSize)
+
+	logMessage(LEVEL_TRACE, TYPE_RENDERER, "Opengl Version:", gl.GoStr(gl.GetString(gl.VERSION)))
+	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "Vendor:", gl.GoStr(gl.GetString(gl.VENDOR)))
+	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "Renderer:", gl.GoStr(gl.GetString(gl.RENDERER)))
+	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "GLSL:", gl.GoStr(gl.GetString(gl.SHADING_LANGUAGE_VERSION)))
+}
+
+// Creates a vertex array for the vbo in the current context. Vertex arrays
+// are not shared between the contexts, and the external windows create their
+// own. Also sets the other states of the context.
+func rglCreateVertexArray() uint32 {
+	var vao uint32
+	gl.GenVertexArrays(1, &vao)
+	gl.BindVertexArray(vao)
 	rglCheckError("gen vao")
 
-	// Initialize vbo
-	gl.GenBuffers(1, &RGL.vbo)
 	gl.BindBuffer(gl.ARRAY_BUFFER, RGL.vbo)
-	rglCheckError("gen vbo")
+	rglCheckError("bind vbo")
 
 	// Enable attributes
 	valueof_Vertex := reflect.ValueOf(Vertex{})
@@ -74,22 +105,25 @@ func rglInit() {
 		offset += attr_size
 	}
 
-	if isDebugBuild() {
-		// We don't need blending. This is only for Renderer.DebugDrawFontAtlas
-		gl.Enable(gl.BLEND)
-		gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
-		rglCheckError("enable blending")
-	}
+	// Blending is always enabled, because all vertices are drawn with one
+	// draw call and the translucent ones are mixed with the others. Cursor
+	// effects, the popup menu with pumblend and the debug atlas are drawn over
+	// the cells with alpha. Opaque cells are the same with or without it, and
+	// the default background with the transparency option is blended over the
+	// clear color which has the same color and alpha.
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	rglCheckError("enable blending")
 
-	// Create framebuffer object
-	// We dont need to bind framebuffer because we need it only when clearing texture
-	gl.GenFramebuffers(1, &RGL.fbo)
-	rglCheckError("gen framebuffer")
+	gl.UseProgram(RGL.shader_program)
+	rglCheckError("use program")
 
-	logMessage(LEVEL_TRACE, TYPE_RENDERER, "Opengl Version:", gl.GoStr(gl.GetString(gl.VERSION)))
-	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "Vendor:", gl.GoStr(gl.GetString(gl.VENDOR)))
-	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "Renderer:", gl.GoStr(gl.GetString(gl.RENDERER)))
-	logMessage(LEVEL_DEBUG, TYPE_RENDERER, "GLSL:", gl.GoStr(gl.GetString(gl.SHADING_LANGUAGE_VERSION)))
+	return vao
+}
+
+func rglDeleteVertexArray(vao uint32) {
+	gl.DeleteVertexArrays(1, &vao)
+	rglCheckError("delete vao")
 }
 
 func rglGetUniformLocation(name string) int32 {
@@ -120,19 +154,30 @@ func rglClearScreen(color U8Color) {
 	rglCheckError("clear color")
 }
 
-func rglUpdateVertices(data []Vertex) {
+// Uploads the changed ranges of the vertex data. All data is uploaded if the
+// length of the data is changed.
+func rglUpdateVertices(data []Vertex, ranges []VertexRange) {
 	if RGL.vertex_buffer_len != len(data) {
 		gl.BufferData(gl.ARRAY_BUFFER, len(data)*int(sizeof_Vertex), unsafe.Pointer(&data[0]), gl.STATIC_DRAW)
 		rglCheckError("vertex buffer data")
 		RGL.vertex_buffer_len = len(data)
-	} else {
-		gl.BufferSubData(gl.ARRAY_BUFFER, 0, len(data)*int(sizeof_Vertex), unsafe.Pointer(&data[0]))
+		return
+	}
+	for _, r := range ranges {
+		if r.end > len(data) {
+			r.end = len(data)
+		}
+		if r.begin >= r.end {
+			continue
+		}
+		gl.BufferSubData(gl.ARRAY_BUFFER, r.begin*int(sizeof_Vertex), (r.end-r.begin)*int(sizeof_Vertex), unsafe.Pointer(&data[r.begin]))
 		rglCheckError("vertex buffer subdata")
 	}
 }
 
-func rglRender() {
-	gl.DrawArrays(gl.POINTS, 0, int32(RGL.vertex_buffer_len))
+// Renders the vertices from the first to the count in the current context.
+func rglRender(first, count int) {
+	gl.DrawArrays(gl.POINTS, int32(first), int32(count))
 	// Since we are not using doublebuffering, we don't need swapping buffers, but we need to flush.
 	gl.Flush()
 	rglCheckError("render")
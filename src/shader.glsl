@@ -8,6 +8,8 @@ layout(location = 2) in vec4 tex2;
 layout(location = 3) in vec4 fg;
 layout(location = 4) in vec4 bg;
 layout(location = 5) in vec4 sp;
+layout(location = 6) in vec2 colored;
+layout(location = 7) in vec2 clip;
 
 uniform mat4 projection;
 uniform vec4 undercurlRect;
@@ -20,6 +22,8 @@ out VS_OUT {
 	vec4 fgColor;
 	vec4 bgColor;
 	vec4 spColor;
+	vec2 colored;
+	vec2 clip;
 } vs_out;
 
 void main() {
@@ -31,6 +35,8 @@ void main() {
 	vs_out.fgColor    = fg;
 	vs_out.bgColor    = bg;
 	vs_out.spColor    = sp;
+	vs_out.colored    = colored;
+	vs_out.clip       = clip;
 }
 
 // Geometry Shader
@@ -46,6 +52,8 @@ in VS_OUT {
 	vec4 fgColor;
 	vec4 bgColor;
 	vec4 spColor;
+	vec2 colored;
+	vec2 clip;
 } gs_in[];
 
 out GS_OUT {
@@ -55,6 +63,7 @@ out GS_OUT {
 	vec4 fgColor;
 	vec4 bgColor;
 	vec4 spColor;
+	vec2 colored;
 } gs_out;
 
 vec2 pluspos[] = vec2[4](
@@ -65,15 +74,26 @@ vec2 pluspos[] = vec2[4](
 );
 
 void main() {
+	vec4 pos  = gl_in[0].gl_Position;
+	vec2 clip = gs_in[0].clip;
+	// Vertical part of the cell which is inside the clip range.
+	vec2 part = vec2(0, 1);
+	if (clip.y > 0 && pos.w > 0) {
+		part = (clamp(vec2(pos.y, pos.y + pos.w), clip.x, clip.y) - pos.y) / pos.w;
+		if (part.x >= part.y) {
+			return;
+		}
+	}
 	for(int i = 0; i < 4; i++) {
-		vec4 pos       = gl_in[0].gl_Position;
-		gl_Position    = vec4(pos.xy + (pluspos[i] * pos.zw), 0, 1) * gs_in[0].projection;
-		gs_out.tex1pos = gs_in[0].tex1pos.xy + (pluspos[i] * gs_in[0].tex1pos.zw);
-		gs_out.tex2pos = gs_in[0].tex2pos.xy + (pluspos[i] * gs_in[0].tex2pos.zw);
-		gs_out.ucPos   = gs_in[0].ucPos.xy + (pluspos[i] * gs_in[0].ucPos.zw);
+		vec2 plus      = vec2(pluspos[i].x, mix(part.x, part.y, pluspos[i].y));
+		gl_Position    = vec4(pos.xy + (plus * pos.zw), 0, 1) * gs_in[0].projection;
+		gs_out.tex1pos = gs_in[0].tex1pos.xy + (plus * gs_in[0].tex1pos.zw);
+		gs_out.tex2pos = gs_in[0].tex2pos.xy + (plus * gs_in[0].tex2pos.zw);
+		gs_out.ucPos   = gs_in[0].ucPos.xy + (plus * gs_in[0].ucPos.zw);
 		gs_out.fgColor = gs_in[0].fgColor;
 		gs_out.bgColor = gs_in[0].bgColor;
 		gs_out.spColor = gs_in[0].spColor;
+		gs_out.colored = gs_in[0].colored;
 		EmitVertex();
 	}
 	EndPrimitive();
@@ -90,6 +110,7 @@ in GS_OUT {
 	vec4 fgColor;
 	vec4 bgColor;
 	vec4 spColor;
+	vec2 colored;
 } fs_in;
 
 uniform sampler2D atlas;
@@ -100,7 +121,12 @@ void main() {
 	vec4 foreground = mix(fs_in.fgColor, fs_in.spColor, ucAlpha);
 	vec4 background = mix(fs_in.bgColor, fs_in.spColor, ucAlpha);
 	// Mix background and foreground color with textures.
-	float texAlpha = max(texture(atlas, fs_in.tex1pos).a, texture(atlas, fs_in.tex2pos).a);
+	vec4 tex1      = texture(atlas, fs_in.tex1pos);
+	vec4 tex2      = texture(atlas, fs_in.tex2pos);
+	float texAlpha = max(tex1.a * (1 - fs_in.colored.x), tex2.a * (1 - fs_in.colored.y));
 	vec4 result    = mix(background, foreground, texAlpha);
+	// Color glyphs are premultiplied and drawn over the result without tinting.
+	vec4 color     = tex1 * fs_in.colored.x + tex2 * fs_in.colored.y;
+	result         = color + result * (1 - color.a);
 	outFragColor   = result;
 }
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/go-text/typesetting/di"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"github.com/go-text/typesetting/language"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Longer runs are splitted at a space. Ligatures are short, and this
+	// keeps the shaping cache small.
+	SHAPER_MAX_RUN_LENGTH = 32
+	// The shaping cache is cleared when it has more runs than this.
+	SHAPER_CACHE_SIZE = 4096
+)
+
+// A glyph in the shaper output.
+type ShapedGlyph struct {
+	id sfnt.GlyphIndex
+	// Index of the cell in the run which this glyph belongs to.
+	cell int
+	// Position of the glyph origin relative to the left of it's cell.
+	x, y fixed.Int26_6
+	// Horizontal ink bounds relative to the origin.
+	left, right fixed.Int26_6
+	// True if the shaper replaced the nominal glyph of the character or moved
+	// it. These glyphs may be spread across multiple cells.
+	substituted bool
+}
+
+// A glyph which is drawn on a cell, position is relative to the cell.
+type CellGlyph struct {
+	id   sfnt.GlyphIndex
+	x, y fixed.Int26_6
+}
+
+// Shaper shapes runs of the cells which have the same highlight attribute, so
+// the ligatures and contextual alternates of the font can be drawn. Every
+// cell still has it's own vertex and atlas texture. A ligature glyph is drawn
+// on every cell it covers, and every cell only draws the part of it which is
+// inside the cell.
+type Shaper struct {
+	handle shaping.HarfbuzzShaper
+	// Features from the guifont option, like -calt or +ss01.
+	features []shaping.FontFeature
+	// Shaped runs, keyed by the face and text.
+	cache map[ShapeKey][]ShapedGlyph
+}
+
+// Key of a shaped run in the cache.
+type ShapeKey struct {
+	face   *FontFace
+	text   [SHAPER_MAX_RUN_LENGTH]rune
+	length int
+}
+
+func CreateShaper() Shaper {
+	return Shaper{
+		cache: make(map[ShapeKey][]ShapedGlyph),
+	}
+}
+
+// Parses font feature flags in the guifont. A feature is enabled with +tag
+// and disabled with -tag, tag must be four characters.
+func parseFontFeature(opt string) (shaping.FontFeature, bool) {
+	if len(opt) != 5 || (opt[0] != '+' && opt[0] != '-') {
+		return shaping.FontFeature{}, false
+	}
+	feature := shaping.FontFeature{
+		Tag: ot.MustNewTag(strings.ToLower(opt[1:])),
+	}
+	if opt[0] == '+' {
+		feature.Value = 1
+	}
+	return feature, true
+}
+
+func (shaper *Shaper) setFeatures(features []shaping.FontFeature) {
+	shaper.features = features
+	shaper.clearCache()
+}
+
+func (shaper *Shaper) clearCache() {
+	shaper.cache = make(map[ShapeKey][]ShapedGlyph)
+}
+
+// Returns the script of the character, and false if the character is used
+// with many scripts, like the punctuations and digits.
+func runeScript(char rune) (language.Script, bool) {
+	script := language.LookupScript(char)
+	switch script {
+	case language.Common, language.Inherited, language.Unknown:
+		return script, false
+	}
+	return script, true
+}
+
+// Returns the script of the first character of the text which has one. Texts
+// without such characters, like operators, are shaped as latin.
+func textScript(text []rune) language.Script {
+	for _, char := range text {
+		if script, ok := runeScript(char); ok {
+			return script
+		}
+	}
+	return language.Latin
+}
+
+// Shapes the text with the shaping face of the face.
+func (shaper *Shaper) shapeText(face *FontFace, text []rune) shaping.Output {
+	return shaper.handle.Shape(shaping.Input{
+		Text:         text,
+		RunStart:     0,
+		RunEnd:       len(text),
+		Direction:    di.DirectionLTR,
+		Face:         face.shapingFace(),
+		FontFeatures: shaper.features,
+		Size:         face.ppem,
+		Script:       textScript(text),
+		Language:     language.DefaultLanguage(),
+	})
+}
+
+// Returns shaped glyphs of the text. Results are cached, except the texts
+// longer than the runs.
+func (shaper *Shaper) shape(face *FontFace, text []rune) []ShapedGlyph {
+	cacheable := len(text) <= SHAPER_MAX_RUN_LENGTH
+	key := ShapeKey{face: face, length: len(text)}
+	if cacheable {
+		copy(key.text[:], text)
+		if glyphs, ok := shaper.cache[key]; ok {
+			return glyphs
+		}
+	}
+	if len(shaper.cache) >= SHAPER_CACHE_SIZE {
+		shaper.clearCache()
+	}
+	handle := face.shapingFace()
+	output := shaper.shapeText(face, text)
+	glyphs := make([]ShapedGlyph, 0, len(output.Glyphs))
+	// Glyphs are placed to the cells of their clusters, the advances are
+	// only used for the glyphs in the same cluster.
+	var pen, clusterBegin fixed.Int26_6
+	cluster := -1
+	for _, g := range output.Glyphs {
+		if g.ClusterIndex != cluster {
+			cluster = g.ClusterIndex
+			clusterBegin = pen
+		}
+		glyph := ShapedGlyph{
+			id:    sfnt.GlyphIndex(g.GlyphID),
+			cell:  cluster,
+			x:     pen - clusterBegin + g.XOffset,
+			y:     -g.YOffset,
+			left:  g.XBearing,
+			right: g.XBearing + g.Width,
+		}
+		nominal, _ := handle.NominalGlyph(text[cluster])
+		glyph.substituted = g.GlyphID != nominal || g.RuneCount > 1 || glyph.x != 0 || glyph.y != 0
+		glyphs = append(glyphs, glyph)
+		pen += g.XAdvance
+	}
+	if cacheable {
+		shaper.cache[key] = glyphs
+	}
+	return glyphs
+}
+
+// Returns the glyphs of the grapheme cluster, positions are relative to the
+// origin of the first glyph. Clusters are not cached like the runs, the atlas
+// keeps their images.
+func (shaper *Shaper) shapeCluster(face *FontFace, text []rune) []CellGlyph {
+	output := shaper.shapeText(face, text)
+	glyphs := make([]CellGlyph, len(output.Glyphs))
+	var pen fixed.Int26_6
+	for i, g := range output.Glyphs {
+		glyphs[i] = CellGlyph{
+			id: sfnt.GlyphIndex(g.GlyphID),
+			x:  pen + g.XOffset,
+			y:  -g.YOffset,
+		}
+		pen += g.XAdvance
+	}
+	return glyphs
+}
+
+// Returns the glyphs which must be drawn on the cell at index of the run, and
+// false if the cell is not affected by shaping and can be drawn like other
+// cells.
+func shapedCellGlyphs(glyphs []ShapedGlyph, index int) ([]CellGlyph, bool) {
+	cellWidth := fixed.I(singleton.cellWidth)
+	shaped := false
+	cellGlyphs := []CellGlyph{}
+	for _, glyph := range glyphs {
+		// Origin of the glyph relative to this cell.
+		x := fixed.Int26_6(glyph.cell-index)*cellWidth + glyph.x
+		if glyph.substituted {
+			if glyph.cell == index {
+				shaped = true
+			}
+			if glyph.left == glyph.right || x+glyph.right <= 0 || x+glyph.left >= cellWidth {
+				// Empty or outside of this cell.
+				continue
+			}
+			shaped = true
+		} else if glyph.cell != index {
+			// Nominal glyphs are only drawn on their own cells.
+			continue
+		}
+		cellGlyphs = append(cellGlyphs, CellGlyph{id: glyph.id, x: x, y: glyph.y})
+	}
+	return cellGlyphs, shaped
+}
+
+// Returns the face for shaping the cell of the row, and false if the cell
+// can't be shaped. Empty, wide, cluster and box drawing cells are never shaped.
+func (renderer *Renderer) cellShapingFace(row []Cell, y int) (*FontFace, bool) {
+	cell := row[y]
+	wide := y+1 < len(row) && row[y+1].char == WIDE_CHAR_CONTINUATION
+	if cell.char == 0 || cell.char == WIDE_CHAR_CONTINUATION || isGraphemeCluster(cell.char) || wide {
+		return nil, false
+	}
+	if singleton.options.boxDrawingEnabled && cell.char >= 0x2500 && cell.char <= 0x259F {
+		return nil, false
+	}
+	attrib := HighlightAttribute{}
+	if cell.attribId > 0 {
+		attrib = singleton.gridManager.attributes[cell.attribId]
+	}
+	face, ok := renderer.getSupportedFace(cell.char, false, attrib.italic, attrib.bold)
+	if !ok || face.colors != nil || face.shapingFace() == nil {
+		// Color glyphs are not shaped.
+		return nil, false
+	}
+	return face, true
+}
+
+// Shapes runs of the cells in the first cols of the row that have the same
+// attribute, font face and script, and calls draw for every cell from left to right.
+// Glyphs are nil for the cells which are not affected by shaping, these cells
+// are drawn as usual.
+func (renderer *Renderer) shapeRow(row []Cell, cols int, draw func(y int, face *FontFace, glyphs []CellGlyph)) {
+	for y := 0; y < cols; {
+		face, ok := renderer.cellShapingFace(row, y)
+		if !ok || !singleton.options.ligaturesEnabled {
+			draw(y, nil, nil)
+			y++
+			continue
+		}
+		begin := y
+		attribId := row[y].attribId
+		script, hasScript := runeScript(row[y].char)
+		text := []rune{}
+		for y < cols && y-begin < SHAPER_MAX_RUN_LENGTH && row[y].attribId == attribId {
+			if next, ok := renderer.cellShapingFace(row, y); !ok || next != face {
+				break
+			}
+			if next, ok := runeScript(row[y].char); ok {
+				if hasScript && next != script {
+					break
+				}
+				script, hasScript = next, true
+			}
+			text = append(text, row[y].char)
+			y++
+		}
+		if y < cols && len(text) == SHAPER_MAX_RUN_LENGTH {
+			// Run is cut by the length limit. It ends after the last space
+			// instead, so the ligatures are not cut.
+			for i := len(text) - 1; i > 0; i-- {
+				if text[i] == ' ' {
+					text = text[:i+1]
+					y = begin + i + 1
+					break
+				}
+			}
+		}
+		glyphs := renderer.shaper.shape(face, text)
+		for i := range text {
+			cellGlyphs, shaped := shapedCellGlyphs(glyphs, i)
+			if !shaped {
+				cellGlyphs = nil
+			}
+			draw(begin+i, face, cellGlyphs)
+		}
+	}
+}
+
+// Draws the row of the grid by shaping it. Only the cells which are not under
+// another grid are drawn.
+func (renderer *Renderer) drawShapedRow(grid *Grid, x, cols int) {
+	gridManager := &singleton.gridManager
+	renderer.shapeRow(grid.cells[x], cols, func(y int, face *FontFace, glyphs []CellGlyph) {
+		cell := grid.getCell(x, y)
+		grid.cells[x][y].needsDraw = false
+		if !gridManager.ownsCell(grid, grid.sRow+x, grid.sCol+y) {
+			// Cell is under another grid.
+			gridManager.redrawBlendedCell(grid.sRow+x, grid.sCol+y)
+			return
+		}
+		if glyphs != nil && !grid.isBlended(cell) {
+			renderer.DrawShapedCell(grid.sRow+x, grid.sCol+y, cell, face, glyphs)
+		} else {
+			renderer.drawGridCell(grid, x, y, cell, grid.isWide(x, y))
+		}
+	})
+}
+
+// Returns the attribute of the shaped cell and the atlas position of it's
+// glyphs.
+func (renderer *Renderer) shapedCellPos(cell Cell, face *FontFace, glyphs []CellGlyph) (HighlightAttribute, IntRect) {
+	attrib := HighlightAttribute{}
+	if cell.attribId > 0 {
+		attrib = singleton.gridManager.attributes[cell.attribId]
+	}
+	// Like the regular characters, only letters are underlined.
+	isLetter := unicode.IsLetter(cell.char)
+	spec := singleton.uiOptions.parsed.guifont
+	atlasPos := renderer.getShapedPos(face, glyphs,
+		(attrib.underline || spec.underline) && isLetter,
+		(attrib.strikethrough || spec.strikethrough) && isLetter)
+	return attrib, atlasPos
+}
+
+// Draws the shaped cell using the glyphs of it.
+func (renderer *Renderer) DrawShapedCell(x, y int, cell Cell, face *FontFace, glyphs []CellGlyph) {
+	attrib, atlasPos := renderer.shapedCellPos(cell, face, glyphs)
+	fg, bg, sp := singleton.gridManager.attribColors(attrib)
+	renderer.setCellBg(x, y, bg)
+	if attrib.undercurl {
+		renderer.checkUndercurlPos()
+		renderer.setCellSp(x, y, sp)
+	} else {
+		renderer.setCellSp(x, y, U8Color{})
+	}
+	if y+1 < renderer.cols {
+		renderer.setCellTex2(x, y+1, IntRect{})
+	}
+	renderer.setCellTex1(x, y, atlasPos)
+	renderer.setCellFg(x, y, fg)
+}
+
+// Same with DrawShapedCell, draws to the cell at index of the storage.
+func (storage VertexDataStorage) setShapedCell(index int, cell Cell, face *FontFace, glyphs []CellGlyph) {
+	attrib, atlasPos := storage.renderer.shapedCellPos(cell, face, glyphs)
+	fg, bg, sp := singleton.gridManager.attribColors(attrib)
+	storage.setCellBg(index, bg)
+	if attrib.undercurl {
+		storage.renderer.checkUndercurlPos()
+		storage.setCellSp(index, sp)
+	} else {
+		storage.setCellSp(index, U8Color{})
+	}
+	if storage.begin+index+1 < storage.end {
+		storage.setCellTex2(index+1, IntRect{})
+	}
+	storage.setCellTex1(index, atlasPos)
+	storage.setCellFg(index, fg)
+}
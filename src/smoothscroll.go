@@ -0,0 +1,241 @@
+package main
+
+import (
+	"math"
+)
+
+// Smooth scrolling of a grid. When neovim scrolls a window, cells of the
+// scrolled region are moved instantly like before, but they are rendered with
+// a pixel offset which goes to zero. The rows scrolled out of the region are
+// drawn at the revealed edge of the region while the offset is not zero.
+type GridScroll struct {
+	// Scrolled region in grid cells, bottom and right are exclusive.
+	top, bot, left, right int
+	// A grid_scroll event may also be sent for inserting or deleting lines.
+	// Scrolls are pending until the win_viewport event confirms the topline
+	// of the window is changed, and dropped at the flush otherwise.
+	pendingRows int
+	pendingBack [][]Cell
+	// Vertical pixel offset of the region.
+	anim   Animation
+	offset float32
+	active bool
+	// Rows scrolled out of the region, from top to bottom. They are above the
+	// region when the offset is positive, and below otherwise.
+	scrollback [][]Cell
+	// Last known topline of the window.
+	topline int
+}
+
+// Called before the grid scrolls the rows. Saves the rows which will be
+// scrolled out of the region.
+func (grid *Grid) queueScroll(top, bot, rows, left, right int) {
+	if singleton.options.scrollAnimTime <= 0 || rows == 0 || !grid.inScreenCells() {
+		return
+	}
+	s := &grid.scrolling
+	if s.pendingRows != 0 && (s.top != top || s.bot != bot || s.left != left || s.right != right ||
+		(s.pendingRows > 0) != (rows > 0)) {
+		// Only the scrolls of the same region are merged.
+		s.pendingRows = 0
+		s.pendingBack = nil
+	}
+	s.top, s.bot, s.left, s.right = top, bot, left, right
+	begin, end := top, top+rows
+	if rows < 0 {
+		begin, end = bot+rows, bot
+	}
+	saved := make([][]Cell, 0, end-begin)
+	for x := max(begin, top); x < min(end, bot); x++ {
+		saved = append(saved, append([]Cell{}, grid.cells[x][left:right]...))
+	}
+	if rows > 0 {
+		s.pendingBack = append(s.pendingBack, saved...)
+	} else {
+		s.pendingBack = append(saved, s.pendingBack...)
+	}
+	s.pendingRows += rows
+}
+
+// Called with the topline of the window from the win_viewport event. Starts
+// animating the pending scroll if the topline is changed.
+func (grid *Grid) setTopline(topline int) {
+	s := &grid.scrolling
+	delta := topline - s.topline
+	s.topline = topline
+	if delta == 0 || s.pendingRows == 0 || (delta > 0) != (s.pendingRows > 0) {
+		return
+	}
+	height := float32(singleton.cellHeight)
+	if s.active && (s.offset > 0) != (s.pendingRows > 0) {
+		// Direction is changed, continue from the current position.
+		s.offset = 0
+		s.scrollback = nil
+	}
+	s.offset += float32(s.pendingRows) * height
+	if s.pendingRows > 0 {
+		s.scrollback = append(s.scrollback, s.pendingBack...)
+	} else {
+		s.scrollback = append(s.pendingBack, s.scrollback...)
+	}
+	// Offset is never more than the height of the region.
+	limit := float32(s.bot-s.top) * height
+	s.offset = f32clamp(s.offset, -limit, limit)
+	needed := int(math.Ceil(math.Abs(float64(s.offset / height))))
+	if len(s.scrollback) > needed {
+		if s.offset > 0 {
+			s.scrollback = s.scrollback[len(s.scrollback)-needed:]
+		} else {
+			s.scrollback = s.scrollback[:needed]
+		}
+	}
+	s.anim = CreateAnimation(F32Vec2{X: s.offset}, F32Vec2{}, singleton.options.scrollAnimTime,
+		AnimationCurves[ANIM_CURVE_EASEOUT])
+	s.active = true
+	s.pendingRows = 0
+	s.pendingBack = nil
+}
+
+// Drops the scrolls which are not confirmed by win_viewport.
+func (gridManager *GridManager) flushScrolls() {
+	for _, grid := range gridManager.grids {
+		grid.scrolling.pendingRows = 0
+		grid.scrolling.pendingBack = nil
+	}
+}
+
+func (gridManager *GridManager) isScrolling() bool {
+	for _, grid := range gridManager.grids {
+		if grid.scrolling.active {
+			return true
+		}
+	}
+	return false
+}
+
+func (gridManager *GridManager) createVertexData() {
+	// Scrollback rows are never more than the cells of the screen, and they
+	// are only drawn when the scroll animation is enabled.
+	gridManager.scrollVertexData = VertexDataStorage{}
+	if singleton.options.scrollAnimTime > 0 {
+		gridManager.scrollVertexData = singleton.renderer.reserveVertexData(singleton.renderer.rows * singleton.renderer.cols)
+	}
+	gridManager.scrollVertexUsed = 0
+	gridManager.scrolledCells = nil
+	// Zoomed grids are drawn above the screen cells.
+	for _, grid := range gridManager.grids {
+		grid.vertexData = VertexDataStorage{}
+		if grid.zoomed() {
+			grid.vertexData = singleton.renderer.reserveVertexData(grid.rows * grid.cols)
+		}
+	}
+}
+
+// Advances the scroll animations and sets the positions of the scrolled
+// cells.
+func (gridManager *GridManager) updateScroll() {
+	if !gridManager.isScrolling() && len(gridManager.scrolledCells) == 0 {
+		return
+	}
+	renderer := &singleton.renderer
+	// Reset the cells moved in the last frame, the grids may be moved or
+	// destroyed after that.
+	for _, rect := range gridManager.scrolledCells {
+		for x := rect.Y; x < rect.Y+rect.H; x++ {
+			for y := rect.X; y < rect.X+rect.W; y++ {
+				renderer.setCellPos(x, y, cellPos(x, y), F32Vec2{})
+			}
+		}
+	}
+	gridManager.scrolledCells = gridManager.scrolledCells[:0]
+	used := 0
+	for _, grid := range gridManager.grids {
+		s := &grid.scrolling
+		if !s.active {
+			continue
+		}
+		offset, finished := s.anim.GetCurrentStep(float32(singleton.time.animDelta))
+		if finished || grid.hidden || !grid.inScreenCells() {
+			s.active = false
+			s.offset = 0
+			s.scrollback = nil
+			continue
+		}
+		s.offset = float32(math.Round(float64(offset.X)))
+		used = grid.drawScroll(used)
+	}
+	// Clear scrollback rows of the last frame.
+	for i := used; i < gridManager.scrollVertexUsed; i++ {
+		gridManager.scrollVertexData.setCellPos(i, F32Rect{})
+	}
+	gridManager.scrollVertexUsed = used
+	singleton.render()
+}
+
+// Moves the cells of the scrolled region by the offset and draws the
+// scrollback rows to the scroll vertex data beginning from the used index.
+// Returns the new used count.
+func (grid *Grid) drawScroll(used int) int {
+	s := &grid.scrolling
+	renderer := &singleton.renderer
+	gridManager := &singleton.gridManager
+	// Region must be inside the grid and the screen.
+	top := grid.sRow + s.top
+	bot := min(grid.sRow+min(s.bot, grid.rows), renderer.rows)
+	left := grid.sCol + s.left
+	right := min(grid.sCol+min(s.right, grid.cols), renderer.cols)
+	if top >= bot || left >= right {
+		return used
+	}
+	// Cells are only visible inside the region.
+	clip := F32Vec2{X: cellPos(top, 0).Y, Y: cellPos(bot, 0).Y}
+	for x := top; x < bot; x++ {
+		for y := left; y < right; y++ {
+			if !gridManager.ownsCell(grid, x, y) {
+				continue
+			}
+			pos := cellPos(x, y)
+			pos.Y += s.offset
+			renderer.setCellPos(x, y, pos, clip)
+		}
+	}
+	gridManager.scrolledCells = append(gridManager.scrolledCells, IntRect{X: left, Y: top, W: right - left, H: bot - top})
+	// Draw the rows which are sliding in. They are shaped like the rows of
+	// the grid, and the cells of a row are next to each other in the vertex
+	// data for the wide characters.
+	storage := gridManager.scrollVertexData
+	for i, row := range s.scrollback {
+		// Rows are placed next to the region, which may be partially outside
+		// of the screen.
+		x := grid.sRow + s.bot + i
+		if s.offset > 0 {
+			x = top - len(s.scrollback) + i
+		}
+		cols := min(len(row), right-left)
+		if storage.begin+used+cols > storage.end {
+			return used
+		}
+		renderer.shapeRow(row, cols, func(j int, face *FontFace, glyphs []CellGlyph) {
+			index := used + j
+			pos := cellPos(x, left+j)
+			pos.Y += s.offset
+			if gridManager.isOccluded(grid, pos) {
+				pos = F32Rect{}
+			}
+			storage.setCellPos(index, pos)
+			storage.setCellClip(index, clip)
+			if glyphs != nil {
+				storage.setShapedCell(index, row[j], face, glyphs)
+				return
+			}
+			attrib := HighlightAttribute{}
+			if row[j].attribId > 0 {
+				attrib = gridManager.attributes[row[j].attribId]
+			}
+			wide := j+1 < len(row) && row[j+1].char == WIDE_CHAR_CONTINUATION
+			storage.setCellWithAttrib(index, row[j].char, wide, attrib)
+		})
+		used += cols
+	}
+	return used
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Long tab and buffer names are truncated to this width.
+const TABLINE_MAX_ITEM_WIDTH = 30
+
+type TablineItem struct {
+	// Tabpage or buffer handle.
+	handle   int
+	name     string
+	current  bool
+	isBuffer bool
+	// Position and width of the item in the strip in cells.
+	col, width int
+}
+
+// Tabline is the neovim's tabline, only used when ext_tabline is enabled.
+// Neoray draws it as a strip at the top of the window, tabs are at the left
+// side and buffers are at the right side. The strip is one row height and
+// grids are placed below it.
+type Tabline struct {
+	tabs    []TablineItem
+	buffers []TablineItem
+	visible bool
+	// Vertex data has one cell for every column of the window.
+	vertexData VertexDataStorage
+	cols       int
+}
+
+func CreateTabline() Tabline {
+	return Tabline{}
+}
+
+func (tabline *Tabline) createVertexData() {
+	tabline.cols = singleton.renderer.cols
+	tabline.vertexData = singleton.renderer.reserveVertexData(tabline.cols)
+	tabline.calcPositions()
+	tabline.Draw()
+}
+
+// Returns row count of the strip.
+func (tabline *Tabline) height() int {
+	if tabline.visible {
+		return 1
+	}
+	return 0
+}
+
+func (tabline *Tabline) Update(tabs, buffers []TablineItem) {
+	tabline.tabs = tabs
+	tabline.buffers = buffers
+	tabline.calcPositions()
+	if !tabline.updateVisibility() {
+		tabline.Draw()
+	}
+}
+
+// Checks showtabline option and tab count, and resizes the grid area if the
+// visibility of the strip has changed. Returns true if changed.
+func (tabline *Tabline) updateVisibility() bool {
+	visible := false
+	switch singleton.uiOptions.showtabline {
+	case 1:
+		visible = len(tabline.tabs) > 1
+	case 2:
+		visible = true
+	}
+	if visible == tabline.visible {
+		return false
+	}
+	tabline.visible = visible
+	if singleton.cellHeight > 0 {
+		rows := singleton.window.height/singleton.cellHeight - tabline.height()
+		singleton.renderer._rows = rows
+		if singleton.mainLoopRunning {
+			singleton.nvim.requestResize(rows, singleton.renderer.cols)
+		}
+	}
+	// Positions of the all cells are changed.
+	singleton.renderer.createVertexData()
+	singleton.fullDraw()
+	return true
+}
+
+func tablineItemName(name string) string {
+	if name == "" {
+		return "[No Name]"
+	}
+	name = filepath.Base(name)
+	chars := splitGraphemes(name)
+	if len(chars) > TABLINE_MAX_ITEM_WIDTH {
+		name = ""
+		for _, c := range chars[:TABLINE_MAX_ITEM_WIDTH-1] {
+			name += graphemeString(c)
+		}
+		name += "…"
+	}
+	return name
+}
+
+// Calculates columns of the items. Tabs are placed from left and buffers are
+// placed from right.
+func (tabline *Tabline) calcPositions() {
+	col := 0
+	for i := range tabline.tabs {
+		item := &tabline.tabs[i]
+		item.col = col
+		item.width = len(splitGraphemes(tablineItemName(item.name))) + 2
+		col += item.width
+	}
+	tabsEnd := col
+	col = singleton.renderer.cols
+	for i := len(tabline.buffers) - 1; i >= 0; i-- {
+		item := &tabline.buffers[i]
+		item.width = len(splitGraphemes(tablineItemName(item.name))) + 2
+		col -= item.width
+		item.col = col
+		if col < tabsEnd {
+			// No space for this buffer, tabs have priority.
+			item.width = 0
+		}
+	}
+}
+
+// Returns the item at the column, nil if there is no item.
+func (tabline *Tabline) itemAt(col int) (*TablineItem, int) {
+	for i := range tabline.tabs {
+		item := &tabline.tabs[i]
+		if col >= item.col && col < item.col+item.width {
+			return item, i
+		}
+	}
+	for i := range tabline.buffers {
+		item := &tabline.buffers[i]
+		if col >= item.col && col < item.col+item.width {
+			return item, i
+		}
+	}
+	return nil, -1
+}
+
+// Returns true if the pos is in the strip.
+func (tabline *Tabline) contains(pos IntVec2) bool {
+	return tabline.visible && pos.Y >= 0 && pos.Y < singleton.cellHeight
+}
+
+// Left click switches to the tab or buffer, middle click closes it.
+func (tabline *Tabline) mouseClick(button glfw.MouseButton, pos IntVec2) {
+	item, index := tabline.itemAt(pos.X / singleton.cellWidth)
+	if item == nil {
+		return
+	}
+	switch button {
+	case glfw.MouseButtonLeft:
+		if item.isBuffer {
+			singleton.nvim.setCurrentBuffer(item.handle)
+		} else {
+			singleton.nvim.setCurrentTabpage(item.handle)
+		}
+	case glfw.MouseButtonMiddle:
+		if item.isBuffer {
+			singleton.nvim.execCommand("bdelete %d", item.handle)
+		} else {
+			singleton.nvim.execCommand("tabclose %d", index+1)
+		}
+	}
+}
+
+func (tabline *Tabline) Draw() {
+	if !tabline.visible {
+		for i := 0; i < tabline.cols; i++ {
+			tabline.vertexData.setCellPos(i, F32Rect{})
+		}
+		return
+	}
+	fill := singleton.gridManager.groupAttrib("TabLineFill")
+	normal := singleton.gridManager.groupAttrib("TabLine")
+	selected := singleton.gridManager.groupAttrib("TabLineSel")
+	chars := make([]rune, tabline.cols)
+	attribs := make([]HighlightAttribute, tabline.cols)
+	for i := range attribs {
+		attribs[i] = fill
+	}
+	for _, items := range [][]TablineItem{tabline.tabs, tabline.buffers} {
+		for _, item := range items {
+			attrib := normal
+			if item.current {
+				attrib = selected
+			}
+			text := splitGraphemes(" " + tablineItemName(item.name) + " ")
+			for i, c := range text {
+				col := item.col + i
+				if i >= item.width || col < 0 || col >= tabline.cols {
+					continue
+				}
+				if c == ' ' {
+					c = 0
+				}
+				chars[col] = c
+				attribs[col] = attrib
+			}
+		}
+	}
+	for i := 0; i < tabline.cols; i++ {
+		// Strip is placed above the first row of the grids.
+		tabline.vertexData.setCellPos(i, cellPos(-1, i))
+		tabline.vertexData.setCellWithAttrib(i, chars[i], isWideGrapheme(chars[i]), attribs[i])
+	}
+	singleton.render()
+}
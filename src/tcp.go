@@ -148,6 +148,7 @@ func CreateServer() (*TCPServer, error) {
 						server.data = append(server.data, data)
 						server.dataReceived.Set(true)
 						server.dataMutex.Unlock()
+						singleton.wakeUp()
 						resp = SIGNAL_OK
 						break
 					}
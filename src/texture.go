@@ -58,12 +58,35 @@ func (texture *Texture) clear() {
 	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, 0)
 }
 
+// Resizes the texture by keeping it's content. Texture id is changed, and the
+// new texture is bound after this call.
+func (texture *Texture) resize(width, height int) {
+	newTexture := CreateTexture(width, height)
+	newTexture.clear()
+	// Copy old texture to the new one
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, RGL.fbo)
+	gl.FramebufferTexture2D(gl.READ_FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, texture.id, 0)
+	rglCheckError("framebuffer texture2d")
+	gl.CopyTexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, 0, 0,
+		int32(min(texture.width, width)), int32(min(texture.height, height)))
+	rglCheckError("texture copy")
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+	texture.Delete()
+	*texture = newTexture
+}
+
 func (texture *Texture) updatePart(image *image.RGBA, dest IntRect) {
 	gl.TexSubImage2D(gl.TEXTURE_2D, 0, int32(dest.X), int32(dest.Y), int32(dest.W), int32(dest.H),
 		gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&image.Pix[0]))
 	rglCheckError("texture update part")
 }
 
+// Binds the texture in the current context. Textures are shared between the
+// contexts but the bindings are not.
+func (texture *Texture) bind() {
+	gl.BindTexture(gl.TEXTURE_2D, texture.id)
+}
+
 func (texture *Texture) glCoords(pos IntRect) F32Rect {
 	return F32Rect{
 		X: float32(pos.X) / float32(texture.width),
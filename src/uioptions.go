@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+
+	tsfont "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"github.com/go-text/typesetting/shaping"
 )
 
 type UIOptions struct {
@@ -12,63 +17,251 @@ type UIOptions struct {
 	emoji         bool
 	guifont       string
 	guifontset    string
-	guifontwide   string // TODO
-	linespace     int    // TODO
-	pumblend      int    // TODO
+	guifontwide   string
+	linespace     int // TODO
+	pumblend      int
 	showtabline   int
 	termguicolors bool
 	// will be implemented soon, currently always true
 	mousehide bool
 	// parsed options for forward usage
 	parsed struct {
-		guifontname string
-		guifontsize float32
+		guifont     FontSpec
+		guifontwide FontSpec
 	}
 }
 
+// FontSpec is the parsed guifont option. The syntax is same as vim's,
+// font names separated with commas and options separated with colons.
+// Options are applied to all fonts in the list.
+type FontSpec struct {
+	names []string
+	// Size in points, zero if not specified.
+	size float32
+	// Width of the cells in points, zero means the width of the font.
+	width float32
+	// Weight of the font between 1 and 1000, zero means regular.
+	weight int
+	// Named instance and axis coordinates of a variable font, like
+	// :ISemiCondensed and :Vwdth=87.5
+	instance string
+	axes     []tsfont.Variation
+	// Styles that are applied to all text.
+	bold          bool
+	italic        bool
+	underline     bool
+	strikethrough bool
+	// Glyphs are not antialiased if this is true.
+	aliased  bool
+	features []shaping.FontFeature
+}
+
 func CreateUIOptions() UIOptions {
 	return UIOptions{
 		mousehide: true,
 	}
 }
 
+// Parses the guifont option and returns an error which contains the invalid
+// token if the option is not valid.
+func parseGuiFont(guifont string) (FontSpec, error) {
+	spec := FontSpec{}
+	// treat underlines like whitespaces
+	guifont = strings.ReplaceAll(guifont, "_", " ")
+	// Fonts are separated with commas and the first one is the main font,
+	// others are fallbacks. Options can be given after any of them.
+	for _, font := range strings.Split(guifont, ",") {
+		parts := strings.Split(font, ":")
+		if name := strings.TrimSpace(parts[0]); name != "" {
+			spec.names = append(spec.names, name)
+		}
+		for _, opt := range parts[1:] {
+			if err := spec.parseOption(opt); err != nil {
+				return FontSpec{}, err
+			}
+		}
+	}
+	return spec, nil
+}
+
+func (spec *FontSpec) parseOption(opt string) error {
+	if opt == "" {
+		return nil
+	}
+	invalid := fmt.Errorf("invalid option '%s'", opt)
+	value := opt[1:]
+	switch opt[0] {
+	case 'h':
+		size, err := strconv.ParseFloat(value, 32)
+		if err != nil || size <= 0 {
+			return invalid
+		}
+		spec.size = float32(size)
+	case 'w':
+		width, err := strconv.ParseFloat(value, 32)
+		if err != nil || width <= 0 {
+			return invalid
+		}
+		spec.width = float32(width)
+	case 'W':
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 || weight > 1000 {
+			return invalid
+		}
+		spec.weight = weight
+	case 'I':
+		if value == "" {
+			return invalid
+		}
+		spec.instance = value
+	case 'V':
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || len(parts[0]) != 4 {
+			return invalid
+		}
+		coord, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil {
+			return invalid
+		}
+		spec.axes = append(spec.axes, tsfont.Variation{Tag: ot.MustNewTag(parts[0]), Value: float32(coord)})
+	case 'b', 'i', 'u', 's':
+		if value != "" {
+			return invalid
+		}
+		switch opt[0] {
+		case 'b':
+			spec.bold = true
+		case 'i':
+			spec.italic = true
+		case 'u':
+			spec.underline = true
+		case 's':
+			spec.strikethrough = true
+		}
+	case 'c':
+		// Charsets are only used by Windows api and we don't need them.
+		if value == "" {
+			return invalid
+		}
+	case 'q':
+		// Quality names and numbers of the Windows api. Only antialiasing
+		// can be changed, others are the same for us.
+		switch strings.ToUpper(value) {
+		case "DEFAULT", "DRAFT", "PROOF", "ANTIALIASED", "CLEARTYPE", "CLEARTYPE_NATURAL",
+			"0", "1", "2", "4", "5", "6":
+			spec.aliased = false
+		case "NONANTIALIASED", "3":
+			spec.aliased = true
+		default:
+			return invalid
+		}
+	case '+', '-':
+		feature, ok := parseFontFeature(opt)
+		if !ok {
+			return invalid
+		}
+		spec.features = append(spec.features, feature)
+	default:
+		return invalid
+	}
+	return nil
+}
+
+// Returns true if the fonts of the both specs are same, which means changing
+// from one to other only needs a resize.
+func (spec FontSpec) sameFonts(other FontSpec) bool {
+	return strings.Join(spec.names, ",") == strings.Join(other.names, ",") &&
+		spec.width == other.width &&
+		spec.weight == other.weight &&
+		spec.instance == other.instance &&
+		fmt.Sprint(spec.axes) == fmt.Sprint(other.axes) &&
+		spec.bold == other.bold &&
+		spec.italic == other.italic
+}
+
+// Loads the fonts of the spec with given size. Fonts that can't be found are
+// reported to the user.
+func (spec FontSpec) loadFonts(size float32) []Font {
+	defer clearFontFileCache()
+	fonts := []Font{}
+	for _, name := range spec.names {
+		font, ok := CreateFont(name, size)
+		if ok {
+			// Variable fonts can have the exact weight, others use the bold
+			// face for the heavy weights.
+			font.setVariations(spec.instance, spec.axes)
+			weighted := font.setWeight(spec.weight)
+			font.setStyle(spec.bold || (!weighted && spec.weight >= 600), spec.italic)
+			font.setWidth(spec.width)
+			fonts = append(fonts, font)
+		} else {
+			logMessage(LEVEL_ERROR, TYPE_NEORAY, "Font", name, "not found!")
+			singleton.nvim.echoErr("Font %s not found!", name)
+		}
+	}
+	return fonts
+}
+
 func (options *UIOptions) setGuiFont(guifont string) {
 	// Load Font
 	if guifont != options.guifont {
 		options.guifont = guifont
-		var size float32 = DEFAULT_FONT_SIZE
-		// treat underlines like whitespaces
-		guifont = strings.ReplaceAll(guifont, "_", " ")
-		// parse font options
-		fontOptions := strings.Split(guifont, ":")
-		name := fontOptions[0]
-		for _, opt := range fontOptions[1:] {
-			if len(opt) > 1 && opt[0] == 'h' {
-				// Font size
-				tsize, err := strconv.ParseFloat(opt[1:], 32)
-				if err == nil {
-					size = float32(tsize)
-				}
-			}
+		spec, err := parseGuiFont(guifont)
+		if err != nil {
+			logMessage(LEVEL_ERROR, TYPE_NEORAY, "Invalid guifont:", err)
+			singleton.nvim.echoErr("Invalid guifont %s: %s", guifont, err)
+			return
+		}
+		size := spec.size
+		if size == 0 {
+			size = DEFAULT_FONT_SIZE
 		}
-		if name == "" {
+		previous := options.parsed.guifont
+		options.parsed.guifont = spec
+		singleton.renderer.shaper.setFeatures(spec.features)
+		if len(spec.names) == 0 {
 			// Disable user font.
 			singleton.renderer.disableUserFont()
 			singleton.renderer.setFontSize(size)
-		} else if name == options.parsed.guifontname {
-			// Names are same, just resize the font
+		} else if spec.sameFonts(previous) {
+			// Fonts are same, just resize them. This also clears the atlas
+			// for the other options.
 			singleton.renderer.setFontSize(size)
 		} else {
-			// Create and set renderers font.
-			font, ok := CreateFont(name, size)
-			if ok {
-				singleton.renderer.setFont(font)
+			// Create and set renderers fonts.
+			fonts := spec.loadFonts(size)
+			if len(fonts) > 0 {
+				singleton.renderer.setFonts(fonts)
 			} else {
-				logMessage(LEVEL_ERROR, TYPE_NEORAY, "Font", name, "not found!")
-				singleton.nvim.echoErr("Font %s not found!", name)
+				options.parsed.guifont = previous
 			}
 		}
-		options.parsed.guifontname = name
-		options.parsed.guifontsize = size
+	}
+}
+
+// Color glyphs are only drawn when the emoji option is set, otherwise they
+// are drawn with the text color.
+func (options *UIOptions) setEmoji(emoji bool) {
+	if emoji != options.emoji {
+		options.emoji = emoji
+		if singleton.mainLoopRunning {
+			singleton.renderer.clearAtlas()
+		}
+	}
+}
+
+// Fonts of the guifontwide are used for the double width cells. Sizes of the
+// fonts are always same with the guifont.
+func (options *UIOptions) setGuiFontWide(guifontwide string) {
+	if guifontwide != options.guifontwide {
+		options.guifontwide = guifontwide
+		spec, err := parseGuiFont(guifontwide)
+		if err != nil {
+			logMessage(LEVEL_ERROR, TYPE_NEORAY, "Invalid guifontwide:", err)
+			singleton.nvim.echoErr("Invalid guifontwide %s: %s", guifontwide, err)
+			return
+		}
+		options.parsed.guifontwide = spec
+		singleton.renderer.setWideFonts(spec.loadFonts(singleton.renderer.defaultFont.size))
 	}
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tsfont "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+)
+
+func Test_parseGuiFont(t *testing.T) {
+	tests := []struct {
+		name    string
+		guifont string
+		want    FontSpec
+		wantErr bool
+	}{
+		{
+			name:    "Name and size",
+			guifont: "Go_Mono:h11",
+			want:    FontSpec{names: []string{"Go Mono"}, size: 11},
+		},
+		{
+			name:    "Fallback fonts",
+			guifont: "JetBrains Mono, Noto Sans CJK JP,Symbols Nerd Font:h12.5",
+			want:    FontSpec{names: []string{"JetBrains Mono", "Noto Sans CJK JP", "Symbols Nerd Font"}, size: 12.5},
+		},
+		{
+			name:    "Only size",
+			guifont: ":h13",
+			want:    FontSpec{size: 13},
+		},
+		{
+			name:    "Styles",
+			guifont: "Consolas:h10:b:i:u:s:w6:W350:cANSI:qNONANTIALIASED",
+			want: FontSpec{
+				names:         []string{"Consolas"},
+				size:          10,
+				width:         6,
+				weight:        350,
+				bold:          true,
+				italic:        true,
+				underline:     true,
+				strikethrough: true,
+				aliased:       true,
+			},
+		},
+		{
+			name:    "Variations",
+			guifont: "Cascadia Code:ISemiLight:Vwdth=87.5:VCRSV=1",
+			want: FontSpec{
+				names:    []string{"Cascadia Code"},
+				instance: "SemiLight",
+				axes: []tsfont.Variation{
+					{Tag: ot.MustNewTag("wdth"), Value: 87.5},
+					{Tag: ot.MustNewTag("CRSV"), Value: 1},
+				},
+			},
+		},
+		{
+			name:    "Invalid axis",
+			guifont: "Cascadia Code:Vwidth=100",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid size",
+			guifont: "Consolas:hx",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid weight",
+			guifont: "Consolas:W1200",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown option",
+			guifont: "Consolas:x11",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid feature",
+			guifont: "Fira Code:+calt1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGuiFont(tt.guifont)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseGuiFont() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGuiFont() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -117,6 +117,10 @@ func (v F32Vec2) normalized() F32Vec2 {
 	return v.divideS(v.length())
 }
 
+func (v F32Vec2) dot(v2 F32Vec2) float32 {
+	return v.X*v2.X + v.Y*v2.Y
+}
+
 func (v F32Vec2) perpendicular() F32Vec2 {
 	return F32Vec2{X: v.Y, Y: -v.X}
 }
@@ -150,6 +154,14 @@ func (rect F32Rect) toInt() IntRect {
 	}
 }
 
+// Returns true if the rectangles have a common area. Empty rectangles don't
+// overlap with anything.
+func (rect F32Rect) overlaps(other F32Rect) bool {
+	return rect.W > 0 && rect.H > 0 && other.W > 0 && other.H > 0 &&
+		rect.X < other.X+other.W && other.X < rect.X+rect.W &&
+		rect.Y < other.Y+other.H && other.Y < rect.Y+rect.H
+}
+
 func min(s ...int) int {
 	m := s[0]
 	for _, v := range s {
@@ -218,31 +230,79 @@ func ortho(top, left, right, bottom, near, far float32) [16]float32 {
 	return matrix
 }
 
+// Animation curves take the elapsed part of the animation between 0 and 1,
+// and return the part of the distance taken. The curve must return 0 for 0 and
+// 1 for 1, and may return values outside of the range between them.
+type AnimationCurve func(t float32) float32
+
+const (
+	ANIM_CURVE_LINEAR  = "linear"
+	ANIM_CURVE_EASEOUT = "easeout"
+	ANIM_CURVE_SPRING  = "spring"
+)
+
+var AnimationCurves = map[string]AnimationCurve{
+	ANIM_CURVE_LINEAR: func(t float32) float32 {
+		return t
+	},
+	// Cubic, slows down at the end.
+	ANIM_CURVE_EASEOUT: func(t float32) float32 {
+		return 1 - (1-t)*(1-t)*(1-t)
+	},
+	// Damped oscillation, passes the target a little and comes back.
+	ANIM_CURVE_SPRING: func(t float32) float32 {
+		return 1 - float32(math.Exp(-7*float64(t))*math.Cos(3*math.Pi*float64(t)))
+	},
+}
+
 type Animation struct {
-	current  F32Vec2
+	from     F32Vec2
 	target   F32Vec2
+	current  F32Vec2
+	curve    AnimationCurve
 	lifeTime float32
+	elapsed  float32
 	finished bool
 }
 
-// Lifetime is the life of the animation. Animation speed is depends of the
-// delta time and lifetime. For lifeTime parameter, 1.0 value is 1 seconds
-func CreateAnimation(from, to F32Vec2, lifeTime float32) Animation {
+// Lifetime is the life of the animation. For lifeTime parameter, 1.0 value is
+// 1 seconds. The curve defines how the animation moves in it's lifetime.
+func CreateAnimation(from, to F32Vec2, lifeTime float32, curve AnimationCurve) Animation {
 	return Animation{
-		current:  from,
+		from:     from,
 		target:   to,
+		current:  from,
+		curve:    curve,
 		lifeTime: lifeTime,
 	}
 }
 
+// Returns true if the animation has started and not finished yet.
+func (anim *Animation) isRunning() bool {
+	return anim.lifeTime > 0 && anim.curve != nil && !anim.finished
+}
+
+// Returns the elapsed part of the animation between 0 and 1.
+func (anim *Animation) progress() float32 {
+	if !anim.isRunning() {
+		return 1
+	}
+	return f32min(anim.elapsed/anim.lifeTime, 1)
+}
+
 // Returns current position of animation
 // If animation is finished, returned bool value will be true
 func (anim *Animation) GetCurrentStep(deltaTime float32) (F32Vec2, bool) {
-	if anim.lifeTime > 0 && deltaTime > 0 && !anim.finished {
-		anim.current = anim.current.plus(anim.target.minus(anim.current).divideS(anim.lifeTime / deltaTime))
-		anim.finished = anim.target.distance(anim.current) < 0.1
-		return anim.current, anim.finished
+	if anim.isRunning() {
+		anim.elapsed += deltaTime
+		if anim.elapsed < anim.lifeTime {
+			step := anim.curve(anim.elapsed / anim.lifeTime)
+			anim.current = anim.from.plus(anim.target.minus(anim.from).multiplyS(step))
+			return anim.current, false
+		}
+		anim.finished = true
 	}
+	anim.current = anim.target
 	return anim.target, true
 }
 
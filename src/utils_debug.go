@@ -16,6 +16,7 @@ const (
 	MINIMUM_LOG_LEVEL       = LEVEL_DEBUG
 	BUILD_TYPE              = DEBUG
 	FONT_ATLAS_DEFAULT_SIZE = 512
+	FONT_ATLAS_MAX_SIZE     = 1024
 )
 
 func start_pprof() {
@@ -36,6 +37,10 @@ func measure_execution_time() func(custom ...string) {
 	return measurer.Measure()
 }
 
+func count_cache_access(name string, hit bool) {
+	measurer.Count(name, hit)
+}
+
 // This function is only for beautifully printing average times and its stuff are unnecessary.
 func close_function_time_tracker() {
 	measurer.Close()
@@ -6,6 +6,7 @@ const (
 	MINIMUM_LOG_LEVEL       = LEVEL_TRACE
 	BUILD_TYPE              = RELEASE
 	FONT_ATLAS_DEFAULT_SIZE = 2048
+	FONT_ATLAS_MAX_SIZE     = 8192
 )
 
 func start_pprof() {}
@@ -14,6 +15,8 @@ func init_function_time_tracker() {}
 
 func measure_execution_time() func(custom ...string) { return func(custom ...string) {} }
 
+func count_cache_access(name string, hit bool) {}
+
 func close_function_time_tracker() {}
 
 // This assert only works on debug build.
@@ -48,6 +48,9 @@ type Window struct {
 	windowedRect IntRect
 	windowState  WindowState
 	cursorHidden bool
+	// Standard mouse cursors are created when they are first used.
+	mouseCursors map[glfw.StandardCursor]*glfw.Cursor
+	mouseCursor  glfw.StandardCursor
 }
 
 func CreateWindow(width int, height int, title string) Window {
@@ -59,7 +62,8 @@ func CreateWindow(width int, height int, title string) Window {
 	logMessage(LEVEL_DEBUG, TYPE_NEORAY, "Monitor count:", len(glfw.GetMonitors()), "Selected monitor:", monitor.GetName())
 	logMessageFmt(LEVEL_DEBUG, TYPE_NEORAY, "Video mode %+v", monitor.GetVideoMode())
 
-	window := Window{title: title}
+	// Window is focused when it is shown.
+	window := Window{title: title, hasfocus: true}
 
 	// Set opengl library version
 	glfw.WindowHint(glfw.ContextVersionMajor, 3)
@@ -107,7 +111,7 @@ func CreateWindow(width int, height int, title string) Window {
 			singleton.window.width = width
 			singleton.window.height = height
 			if width > 0 && height > 0 {
-				rows := height / singleton.cellHeight
+				rows := height/singleton.cellHeight - singleton.tabline.height()
 				cols := width / singleton.cellWidth
 				// Only resize if rows or cols has changed.
 				if rows != singleton.renderer.rows || cols != singleton.renderer.cols {
@@ -120,7 +124,9 @@ func CreateWindow(width int, height int, title string) Window {
 
 	window.handle.SetFocusCallback(
 		func(w *glfw.Window, focused bool) {
-			window.hasfocus = focused
+			singleton.window.hasfocus = focused
+			// Cursor is drawn as an outline when the window is not focused.
+			singleton.cursor.needsDraw = true
 		})
 
 	window.handle.SetIconifyCallback(
@@ -224,6 +230,23 @@ func (window *Window) showCursor() {
 	}
 }
 
+// Sets the shape of the mouse cursor.
+func (window *Window) setMouseCursor(shape glfw.StandardCursor) {
+	if window.mouseCursor == shape {
+		return
+	}
+	if window.mouseCursors == nil {
+		window.mouseCursors = make(map[glfw.StandardCursor]*glfw.Cursor)
+	}
+	cursor, ok := window.mouseCursors[shape]
+	if !ok {
+		cursor = glfw.CreateStandardCursor(shape)
+		window.mouseCursors[shape] = cursor
+	}
+	window.handle.SetCursor(cursor)
+	window.mouseCursor = shape
+}
+
 func (window *Window) raise() {
 	if window.windowState == WINDOW_STATE_MINIMIZED {
 		window.handle.Restore()
@@ -274,7 +297,7 @@ func (window *Window) setTitle(title string) {
 func (window *Window) setSize(width, height int, inCellSize bool) {
 	if inCellSize {
 		width *= singleton.cellWidth
-		height *= singleton.cellHeight
+		height = (height + singleton.tabline.height()) * singleton.cellHeight
 	}
 	if width <= 0 {
 		width = window.width
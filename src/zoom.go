@@ -0,0 +1,228 @@
+package main
+
+import (
+	"math"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+const (
+	GRID_ZOOM_MIN = 0.25
+	GRID_ZOOM_MAX = 4
+)
+
+// Zoomed grids have their own cell size, which is the cell size of the font
+// multiplied by the scale of the window. Glyphs of them are rendered to the
+// atlas with the scaled font size and cell size. A zoomed grid is not drawn
+// to the screen cells, it's cells are in it's own vertex data above them.
+// Splits keep their places in the layout and neovim is requested to resize
+// their grids to fit in it, floats are resized with the scale.
+
+// Key of the scaled faces. Size of the face is in the key because the faces
+// are resized without clearing the atlas.
+type scaledFaceKey struct {
+	face  *FontFace
+	size  float64
+	scale float32
+}
+
+// Returns the scale of the grid, 1 if the grid is not zoomed. The default grid
+// and the external windows are never zoomed.
+func (grid *Grid) scale() float32 {
+	if grid.id == 1 || grid.typ == GridTypeExternal {
+		return 1
+	}
+	if scale, ok := singleton.gridManager.layout.zooms[grid.window]; ok {
+		return scale
+	}
+	return 1
+}
+
+func (grid *Grid) zoomed() bool {
+	return grid.scale() != 1
+}
+
+// Returns true if the grid is drawn to the screen cells of the renderer.
+func (grid *Grid) inScreenCells() bool {
+	return grid.typ != GridTypeExternal && !grid.zoomed()
+}
+
+// Returns the width and height of the cells of the grid in pixels.
+func (grid *Grid) cellSize() (int, int) {
+	return scaledCellSize(grid.scale())
+}
+
+// Returns the width and height of the cells which have the scale in pixels.
+func scaledCellSize(scale float32) (int, int) {
+	if scale == 1 {
+		return singleton.cellWidth, singleton.cellHeight
+	}
+	width := int(math.Round(float64(singleton.cellWidth) * float64(scale)))
+	height := int(math.Round(float64(singleton.cellHeight) * float64(scale)))
+	return max(width, 1), max(height, 1)
+}
+
+// Calls the function while the cell size is the given size. Glyphs are
+// rendered to the cells of the zoomed grids with this, the font faces render
+// the glyphs with the cell size.
+func withCellSize(width, height int, fn func()) {
+	cellWidth, cellHeight := singleton.cellWidth, singleton.cellHeight
+	singleton.cellWidth, singleton.cellHeight = width, height
+	defer func() {
+		singleton.cellWidth, singleton.cellHeight = cellWidth, cellHeight
+	}()
+	fn()
+}
+
+// Returns a copy of the face which is resized with the scale. Copies share
+// the font data and the shaping face with the original face.
+func (renderer *Renderer) scaledFace(face *FontFace, scale float32) *FontFace {
+	key := scaledFaceKey{face: face, size: face.size, scale: scale}
+	if scaled, ok := renderer.scaledFaces[key]; ok {
+		return scaled
+	}
+	scaled := *face
+	scaled.buffer = sfnt.Buffer{}
+	scaled.Resize(float32(face.size) * scale)
+	// Glyphs are centered in the cells like the original face.
+	cellWidth, _ := scaledCellSize(scale)
+	scaled.offset = 0
+	if face.offset != 0 {
+		scaled.offset = (cellWidth - scaled.advance) / 2
+	}
+	renderer.scaledFaces[key] = &scaled
+	return &scaled
+}
+
+// Returns the rectangle of the cell of the grid in pixels. Same with the
+// cellPos of the screen cell when the grid is not zoomed.
+func (grid *Grid) cellPos(x, y int) F32Rect {
+	if !grid.zoomed() {
+		return cellPos(grid.sRow+x, grid.sCol+y)
+	}
+	origin := cellPos(grid.sRow, grid.sCol)
+	width, height := grid.cellSize()
+	return F32Rect{
+		X: origin.X + float32(y*width),
+		Y: origin.Y + float32(x*height),
+		W: float32(width),
+		H: float32(height),
+	}
+}
+
+// Returns the screen cell which the cell of the grid is on.
+func (grid *Grid) screenCell(x, y int) (int, int) {
+	width, height := grid.cellSize()
+	return grid.sRow + x*height/singleton.cellHeight, grid.sCol + y*width/singleton.cellWidth
+}
+
+// Returns the rectangle of the grid in pixels.
+func (grid *Grid) pixelRect() F32Rect {
+	origin := cellPos(grid.sRow, grid.sCol)
+	width, height := grid.cellSize()
+	return F32Rect{
+		X: origin.X,
+		Y: origin.Y,
+		W: float32(grid.cols * width),
+		H: float32(grid.rows * height),
+	}
+}
+
+// Returns the rows and columns of the screen which the grid covers. Zoomed
+// splits cover their place in the layout.
+func (grid *Grid) screenSize() (int, int) {
+	if !grid.zoomed() {
+		return grid.rows, grid.cols
+	}
+	if grid.typ == GridTypeNormal {
+		return grid.layoutRows, grid.layoutCols
+	}
+	width, height := grid.cellSize()
+	rows := (grid.rows*height + singleton.cellHeight - 1) / singleton.cellHeight
+	cols := (grid.cols*width + singleton.cellWidth - 1) / singleton.cellWidth
+	return rows, cols
+}
+
+// Sets the scale of the neovim window, 1 removes the zoom.
+func (gridManager *GridManager) setZoom(win int, scale float32) {
+	if scale == 1 {
+		delete(gridManager.layout.zooms, win)
+	} else {
+		gridManager.layout.zooms[win] = scale
+	}
+	if !singleton.mainLoopRunning {
+		return
+	}
+	for _, grid := range gridManager.grids {
+		if grid.window == win {
+			gridManager.fitToLayout(grid)
+		}
+	}
+	singleton.renderer.createVertexData()
+	singleton.fullDraw()
+}
+
+// Draws the changed cells of the zoomed grid, or all cells if fullDraw is
+// true. Glyphs are rendered with the scale of the grid. Cells are drawn
+// without shaping, and the cells under the higher grids are not drawn. Floats
+// with winblend have translucent backgrounds.
+func (grid *Grid) drawZoomed(fullDraw bool) {
+	storage := grid.vertexData
+	if storage.end-storage.begin != grid.rows*grid.cols {
+		// Vertex data is created again before the next draw.
+		return
+	}
+	if grid.hidden {
+		if fullDraw {
+			for i := 0; i < storage.end-storage.begin; i++ {
+				storage.setCellPos(i, F32Rect{})
+			}
+		}
+		return
+	}
+	gridManager := &singleton.gridManager
+	scale := grid.scale()
+	for x := 0; x < grid.rows; x++ {
+		for y := 0; y < grid.cols; y++ {
+			cell := grid.getCell(x, y)
+			if !fullDraw && !cell.needsDraw {
+				continue
+			}
+			index := x*grid.cols + y
+			pos := grid.cellPos(x, y)
+			if gridManager.isOccluded(grid, pos) {
+				pos = F32Rect{}
+			}
+			storage.setCellPos(index, pos)
+			attrib := HighlightAttribute{}
+			if cell.attribId > 0 {
+				attrib = gridManager.attributes[cell.attribId]
+			}
+			storage.setScaledCellWithAttrib(index, cell.char, grid.isWide(x, y), attrib, scale)
+			if grid.isBlended(cell) {
+				// Zoomed cells are drawn above the screen cells like the
+				// popup menu, the blend makes the background translucent.
+				_, bg, _ := gridManager.attribColors(attrib)
+				bg.A = uint8(int(bg.A) * (100 - clamp(attrib.blend, 0, 100)) / 100)
+				storage.setCellBg(index, bg)
+			}
+			grid.cells[x][y].needsDraw = false
+		}
+	}
+}
+
+// Returns true if the rectangle overlaps with a visible grid which is drawn
+// after the grid.
+func (gridManager *GridManager) isOccluded(grid *Grid, rect F32Rect) bool {
+	above := false
+	for _, other := range gridManager.sortedGrids {
+		if other == grid {
+			above = true
+			continue
+		}
+		if above && !other.hidden && other.typ != GridTypeExternal && rect.overlaps(other.pixelRect()) {
+			return true
+		}
+	}
+	return false
+}